@@ -0,0 +1,319 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// BatchError 批量执行失败时返回的错误，Index 为第一个执行失败的元素在切片中的索引
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch index %d,%s", e.Index, e.Err.Error())
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchState 批量执行的进度，按照 commitSize 分块提交时，重试从第一个没有提交的元素开始执行
+type batchState struct {
+	// committed 已经提交的元素数量
+	committed int
+	// counts 已经执行的元素影响的行数
+	counts []int64
+	// locks 已经提交的元素的乐观锁，pending 为最后一个分块中执行成功的元素的乐观锁，在最终提交之后回写
+	locks, pending []*versionLock
+	// templates 按照执行顺序记录执行过的 sql 模板
+	templates []string
+}
+
+// batchStatement 批量执行，batch 切片中的每个元素和 inv.Args 上下文数据合并之后作为一组参数执行同一条语句
+// 所有元素在同一个事务中执行(调用方传入事务时使用调用方的事务)，相同的 sql 模板在一个事务中只会预编译一次，失败时整个事务回滚
+// batchSize 只控制每执行多少个元素检查一次 ctx 是否被取消并输出执行进度，不会提交事务
+// 显式配置了 commitSize 并且由 GoBatis 开启事务时，每执行 commitSize 个元素提交一次事务并开启新的事务，失败时只回滚没有提交的分块，
+// 在调用方的事务中执行时 commitSize 不生效
+// 元素是基础数据类型时，可以在模板中通过 {item} 取到元素本身
+// 返回值为 []int64 时写入每个元素影响的行数，为 int64 时写入影响的总行数，失败时返回 *BatchError
+// 每个元素渲染时都会调用拦截器的 BeforeRender，AfterRender，执行完成后 inv.Sql 为执行过的所有 sql 模板
+// update 语句的元素带有版本号字段时按照乐观锁执行，执行成功的版本号记录在 state 中，由调用方在提交之后回写
+func (batis *GoBatis) batchStatement(inv *Invocation, id []string, db, ctx reflect.Value, BeginCall *reflect.Value, auto bool, batch reflect.Value, state *batchState, result []reflect.Value) (int64, reflect.Value) {
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	// 重试时丢弃上一次执行中没有提交的结果
+	state.counts, state.pending = state.counts[:state.committed], nil
+	fail := func(index int, err error) (int64, reflect.Value) {
+		BatchResultMapper(result, state.counts)
+		inv.Sql = strings.Join(state.templates, ";\r\n")
+		return sum(state.counts), reflect.ValueOf(&BatchError{Index: index, Err: err})
+	}
+	size, err := batis.batchSize(id, "batchSize", batis.BatchSize)
+	if err != nil {
+		return 0, reflect.ValueOf(err)
+	}
+	commitSize, err := batis.batchSize(id, "commitSize", batis.BatchCommitSize)
+	if err != nil {
+		return 0, reflect.ValueOf(err)
+	}
+	element, err := batis.element(id)
+	if err != nil {
+		return 0, reflect.ValueOf(err)
+	}
+	keys, err := keyConfig(element, batis.Dialect)
+	if err != nil {
+		return 0, reflect.ValueOf(err)
+	}
+	if keys != nil && batch.Len() > 0 {
+		// 通过第一个元素确定主键对应的数据库字段
+		keys.addTarget(batch.Index(0))
+		keys.resolveColumn()
+	}
+	var opts *sql.TxOptions
+	if auto {
+		if opts, err = batis.txOptions(id); err != nil {
+			return 0, reflect.ValueOf(err)
+		}
+	}
+	c := ctx.Interface().(context.Context)
+	exec := db
+	var stmts map[string]*sql.Stmt
+	closeStmts := func() {
+		for _, stmt := range stmts {
+			stmt.Close()
+		}
+		stmts = map[string]*sql.Stmt{}
+	}
+	defer closeStmts()
+	// begin 开启一个分块的事务，预编译的语句只在所属的事务中有效
+	begin := func() error {
+		closeStmts()
+		if !auto {
+			return nil
+		}
+		call := db.MethodByName("BeginTx").Call([]reflect.Value{ctx, reflect.ValueOf(opts)})
+		if !call[1].IsZero() {
+			return call[1].Interface().(error)
+		}
+		*BeginCall = call[0]
+		exec = call[0]
+		return nil
+	}
+	if err = begin(); err != nil {
+		return 0, reflect.ValueOf(err)
+	}
+	for i := state.committed; i < batch.Len(); i++ {
+		if auto && commitSize > 0 && i > state.committed && i%commitSize == 0 {
+			// 提交当前分块，之后的元素在新的事务中执行
+			closeStmts()
+			commit := BeginCall.MethodByName("Commit").Call(nil)
+			*BeginCall = reflect.Value{}
+			if !commit[0].IsZero() {
+				state.counts = state.counts[:state.committed]
+				return fail(state.committed, commit[0].Interface().(error))
+			}
+			state.committed = i
+			state.locks, state.pending = append(state.locks, state.pending...), nil
+			if err = begin(); err != nil {
+				return fail(i, err)
+			}
+		}
+		if size > 0 && i > 0 && i%size == 0 {
+			if err = c.Err(); err != nil {
+				return fail(i, err)
+			}
+			batis.log(c, LogDebug, "sql batch progress", Field{Key: "namespace", Value: id[0]}, Field{Key: "id", Value: id[1]}, Field{Key: "executed", Value: i}, Field{Key: "total", Value: batch.Len()})
+		}
+		elem := *inv
+		elem.Args = batchArg(inv.Args, batch.Index(i))
+		if err = batis.render(&elem); err != nil {
+			return fail(i, err)
+		}
+		templateSql, params := elem.Sql, elem.Params
+		if templateSql, err = batis.batchShard(id, templateSql, elem.Args); err != nil {
+			return fail(i, err)
+		}
+		var lock *versionLock
+		if inv.Tag == Update {
+			if lock, err = versionField(batch.Index(i)); err == nil && lock != nil {
+				templateSql, params, err = lock.rewrite(templateSql, params)
+			}
+			if err != nil {
+				return fail(i, err)
+			}
+		}
+		returningSql, b := batis.Dialect.Returning(templateSql, keyColumn(keys))
+		useReturning := keys != nil && b
+		if useReturning {
+			templateSql = returningSql
+		}
+		stmt, b := stmts[templateSql]
+		if !b {
+			call := exec.MethodByName("PrepareContext").Call([]reflect.Value{ctx, reflect.ValueOf(batis.Dialect.Rebind(templateSql))})
+			if !call[1].IsZero() {
+				return fail(i, call[1].Interface().(error))
+			}
+			stmt = call[0].Interface().(*sql.Stmt)
+			stmts[templateSql] = stmt
+			if !contains(state.templates, templateSql) {
+				state.templates = append(state.templates, templateSql)
+			}
+		}
+		res, err := batchExec(c, stmt, params, keys, useReturning, batch.Index(i), batis.Dialect)
+		if err != nil {
+			return fail(i, maskError(err, params))
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return fail(i, err)
+		}
+		if lock != nil {
+			if count == 0 {
+				return fail(i, ErrOptimisticLock)
+			}
+			state.pending = append(state.pending, lock)
+		}
+		state.counts = append(state.counts, count)
+	}
+	BatchResultMapper(result, state.counts)
+	inv.Sql = strings.Join(state.templates, ";\r\n")
+	return sum(state.counts), errType
+}
+
+// sum 批量执行影响的总行数
+func sum(counts []int64) int64 {
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// batchArg 批量中一个元素的上下文数据，元素是基础数据类型时通过 item 取到元素本身
+func batchArg(base map[string]any, item reflect.Value) map[string]any {
+	arg := make(map[string]any)
+	mergeMap(arg, base)
+	value := item.Interface()
+	if m := toMap(value); len(m) > 0 {
+		mergeMap(arg, m)
+	} else {
+		arg["item"] = value
+	}
+	return arg
+}
+
+// batchRoute 按照第一个元素的分片路由选择批量执行的数据源，没有分片规则或者切片为空时保持当前数据源
+func (batis *GoBatis) batchRoute(id []string, args *Arguments, explicit bool) (*GoBatis, error) {
+	if len(batis.shards) == 0 || args.Batch.Len() == 0 {
+		return batis, nil
+	}
+	arg := batchArg(args.Args, args.Batch.Index(0))
+	_, templateSql, _, err := batis.get(id, arg)
+	if err != nil {
+		return nil, &BatchError{Index: 0, Err: err}
+	}
+	routes, err := batis.route(templateSql, arg)
+	if err != nil || len(routes) != 1 {
+		// 错误在 batchStatement 中按照元素返回
+		return batis, nil
+	}
+	source, err := batis.routed(routes[0])
+	if err != nil {
+		return nil, err
+	}
+	source.conn(args, explicit)
+	return source, nil
+}
+
+// batchShard 替换批量中一个元素的分片表名，批量中所有元素需要路由到 batis 所在的数据源
+func (batis *GoBatis) batchShard(id []string, templateSql string, arg map[string]any) (string, error) {
+	if len(batis.shards) == 0 {
+		return templateSql, nil
+	}
+	// 分片没有指定数据源时使用语句本身配置的数据源
+	bound, err := batis.bind(id)
+	if err != nil {
+		return "", err
+	}
+	routes, err := bound.route(templateSql, arg)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case len(routes) == 0:
+		return templateSql, nil
+	case len(routes) > 1:
+		return "", fmt.Errorf("shard fan-out is only supported for select")
+	case routes[0].source != batis.source:
+		return "", fmt.Errorf("shard routed to data source '%s', batch executes on data source '%s'", routes[0].source, batis.source)
+	}
+	return routes[0].rewrite(templateSql), nil
+}
+
+// batchExec 执行批量中的一个元素，开启 useGeneratedKeys 时把生成的主键回写到 item
+func batchExec(ctx context.Context, stmt *sql.Stmt, params []any, keys *generatedKeys, useReturning bool, item reflect.Value, dialect Dialect) (sql.Result, error) {
+	if keys == nil {
+		return stmt.ExecContext(ctx, rawParams(params)...)
+	}
+	keys.targets = keys.targets[:0]
+	keys.addTarget(item)
+	if useReturning {
+		rows, err := stmt.QueryContext(ctx, rawParams(params)...)
+		if err != nil {
+			return nil, err
+		}
+		return returning(rows, keys)
+	}
+	exec, err := stmt.ExecContext(ctx, rawParams(params)...)
+	if err != nil {
+		return nil, err
+	}
+	return exec, keys.fill(dialect, exec)
+}
+
+// batchSize 批量执行的分块大小，name 为 batchSize 时是检查 ctx 和输出进度的间隔，为 commitSize 时是分块提交的大小
+// 优先使用语句标签上 name 对应的属性，没有配置时使用 def
+func (batis *GoBatis) batchSize(id []string, name string, def int) (int, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return 0, err
+	}
+	if attr := element.SelectAttr(name); attr != nil && attr.Value != "" {
+		size, err := strconv.Atoi(attr.Value)
+		if err != nil {
+			return 0, fmt.Errorf("%s,%s,%s '%s' is not a number", element.Tag, id[1], name, attr.Value)
+		}
+		return size, nil
+	}
+	return def, nil
+}
+
+// BatchResultMapper 批量执行结果赋值
+// 规则:
+// 返回值为 []int64 时，按照切片元素的顺序写入每个元素影响的行数
+// 返回值为 int64 时，写入所有元素影响的总行数
+func BatchResultMapper(result []reflect.Value, counts []int64) {
+	total := sum(counts)
+	for i := 0; i < len(result)-1; i++ {
+		switch {
+		case result[i].Type() == reflect.TypeOf(counts):
+			result[i].Set(reflect.ValueOf(counts))
+		case result[i].Kind() == reflect.Int64:
+			result[i].SetInt(total)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type batchMapper struct {
+	Add        func(ctx context.Context, rows []map[string]any) ([]int64, error)
+	AddPaced   func(ctx context.Context, rows []map[string]any) (int64, error)
+	AddChunked func(ctx context.Context, rows []map[string]any) (int64, error)
+}
+
+// sqlRecorder 记录最后一次执行的 sql 模板
+type sqlRecorder struct {
+	BaseInterceptor
+	sql *string
+}
+
+func (r sqlRecorder) AfterResult(inv *Invocation) error {
+	*r.sql = inv.Sql
+	return nil
+}
+
+func TestBatchStatement(t *testing.T) {
+	fail := map[string]error{}
+	db := &fakeDB{exec: func(_ context.Context, _ string, args []any) (driver.Result, error) {
+		if err := fail[args[0].(string)]; err != nil {
+			delete(fail, args[0].(string))
+			return nil, err
+		}
+		return driver.RowsAffected(1), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="batchMapper">
+    <insert id="Add">
+        insert into user (name) values ({name})
+        <if expr="{vip}">, ('vip')</if>
+    </insert>
+    <insert id="AddPaced" batchSize="2">insert into user (name) values ({name})</insert>
+    <insert id="AddChunked" commitSize="2">insert into user (name) values ({name})</insert>
+</mapper>`)
+	var executed string
+	batis.Use(sqlRecorder{sql: &executed})
+	batis.Retry = RetryPolicy{MaxAttempts: 2, Backoff: func(int) time.Duration { return 0 }}
+	mapper := &batchMapper{}
+	batis.ScanMappers(mapper)
+	rows := func(names ...string) []map[string]any {
+		list := make([]map[string]any, len(names))
+		for i, name := range names {
+			list[i] = map[string]any{"name": name, "vip": name == "vip"}
+		}
+		return list
+	}
+
+	counts, err := mapper.Add(context.Background(), rows("a", "vip", "b"))
+	if err != nil || !reflect.DeepEqual(counts, []int64{1, 1, 1}) {
+		t.Fatal(counts, err)
+	}
+	if log := db.history(); log[0] != "begin" || log[len(log)-1] != "commit" || len(statements(log, "exec")) != 3 {
+		t.Fatalf("%q", log)
+	}
+	// 执行过的 sql 模板按照第一次执行的顺序输出
+	if templates := strings.Split(executed, ";\r\n"); len(templates) != 2 || strings.Contains(templates[0], "vip") || !strings.Contains(templates[1], "vip") {
+		t.Fatalf("%q", executed)
+	}
+
+	fail["b"] = errors.New("duplicate")
+	counts, err = mapper.Add(context.Background(), rows("a", "b", "c"))
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 || !reflect.DeepEqual(counts, []int64{1}) {
+		t.Fatal(counts, err)
+	}
+	if log := db.history(); log[len(log)-1] != "rollback" || len(statements(log, "commit")) != 0 {
+		t.Fatalf("%q", log)
+	}
+
+	// batchSize 不影响事务，失败时整个切片回滚
+	fail["d"] = errors.New("duplicate")
+	total, err := mapper.AddPaced(context.Background(), rows("a", "b", "c", "d", "e"))
+	if !errors.As(err, &batchErr) || batchErr.Index != 3 || total != 3 {
+		t.Fatal(total, err)
+	}
+	if log := db.history(); log[0] != "begin" || log[len(log)-1] != "rollback" || len(statements(log, "begin")) != 1 || len(statements(log, "commit")) != 0 {
+		t.Fatalf("%q", log)
+	}
+	if total, err = mapper.AddPaced(context.Background(), rows("a", "b", "c", "d", "e")); err != nil || total != 5 {
+		t.Fatal(total, err)
+	}
+	if log := db.history(); len(statements(log, "begin")) != 1 || len(statements(log, "commit")) != 1 || log[len(log)-1] != "commit" {
+		t.Fatalf("%q", log)
+	}
+
+	// commitSize 每 2 个元素提交一次，失败只回滚最后一个分块
+	fail["d"] = errors.New("duplicate")
+	total, err = mapper.AddChunked(context.Background(), rows("a", "b", "c", "d", "e"))
+	if !errors.As(err, &batchErr) || batchErr.Index != 3 || total != 3 {
+		t.Fatal(total, err)
+	}
+	want := []string{"begin", "exec insert into user (name) values (?) [a]", "exec insert into user (name) values (?) [b]", "commit",
+		"begin", "exec insert into user (name) values (?) [c]", "exec insert into user (name) values (?) [d]", "rollback"}
+	if log := db.history(); !reflect.DeepEqual(log, want) {
+		t.Fatalf("%q", log)
+	}
+
+	// 瞬时错误重试时从第一个没有提交的分块开始执行
+	fail["d"] = stateError("40001")
+	if total, err = mapper.AddChunked(context.Background(), rows("a", "b", "c", "d", "e")); err != nil || total != 5 {
+		t.Fatal(total, err)
+	}
+	log := db.history()
+	if len(statements(log, "exec insert into user (name) values (?) [a]")) != 1 || len(statements(log, "exec insert into user (name) values (?) [c]")) != 2 || len(statements(log, "commit")) != 3 {
+		t.Fatalf("%q", log)
+	}
+}
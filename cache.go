@@ -0,0 +1,460 @@
+package gobatis
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	// CacheLRU 缓存满时淘汰最久没有被访问的结果
+	CacheLRU = "LRU"
+	// CacheFIFO 缓存满时淘汰最早缓存的结果
+	CacheFIFO = "FIFO"
+)
+
+// defaultCacheSize cache 标签没有配置 size 时缓存的结果数量
+const defaultCacheSize = 1024
+
+// Cache 查询结果缓存的存储，每个配置了 cache 标签的命名空间使用一个 Cache
+// value 为 GoBatis 缓存的查询结果，配置了 GoBatis.CacheCodec 时为编码之后的 []byte，ttl 为 0 表示不过期
+// Get 和 Set 返回错误时查询直接访问数据库，错误以 Warn 级别输出日志
+type Cache interface {
+	Get(ctx context.Context, key string) (value any, ok bool, err error)
+	Set(ctx context.Context, key string, value any, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Clear 清空命名空间的全部缓存，命名空间中的语句执行 insert，update，delete 之后调用
+	Clear(ctx context.Context) error
+}
+
+// CacheConfig mapper 文件中 cache 标签的配置
+type CacheConfig struct {
+	// Eviction 淘汰策略 CacheLRU 或者 CacheFIFO
+	Eviction string
+	// TTL 缓存有效期，0 表示不过期
+	TTL time.Duration
+	// Size 最多缓存的结果数量
+	Size int
+	// FlushOn 这些命名空间执行 insert，update，delete 之后同样清空缓存
+	FlushOn []string
+}
+
+// CacheCodec 缓存结果的序列化，进程外的 Cache 需要配置，编码的数据为 mapper 函数的返回值
+type CacheCodec interface {
+	Encode(value any) ([]byte, error)
+	Decode(data []byte, value any) error
+}
+
+// JSONCodec 使用 encoding/json 序列化缓存结果
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Decode(data []byte, value any) error {
+	return json.Unmarshal(data, value)
+}
+
+// memoryCache 进程内的缓存，按照 LRU 或者 FIFO 淘汰
+type memoryCache struct {
+	size    int
+	lru     bool
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// memoryEntry memoryCache 中的一个结果
+type memoryEntry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewLRUCache 创建进程内的 LRU 缓存，size 为最多缓存的结果数量
+func NewLRUCache(size int) Cache {
+	return newMemoryCache(size, true)
+}
+
+// NewFIFOCache 创建进程内的 FIFO 缓存，size 为最多缓存的结果数量
+func NewFIFOCache(size int) Cache {
+	return newMemoryCache(size, false)
+}
+
+func newMemoryCache(size int, lru bool) *memoryCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &memoryCache{size: size, lru: lru, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get 取出 key 对应的缓存结果，过期的结果会被删除
+func (cache *memoryCache) Get(_ context.Context, key string) (any, bool, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, b := cache.entries[key]
+	if !b {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+		return nil, false, nil
+	}
+	if cache.lru {
+		cache.order.MoveToFront(element)
+	}
+	return entry.value, true, nil
+}
+
+// Set 缓存结果，超出 size 时按照淘汰策略删除最后的结果
+func (cache *memoryCache) Set(_ context.Context, key string, value any, ttl time.Duration) error {
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if element, b := cache.entries[key]; b {
+		cache.order.Remove(element)
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	for cache.order.Len() > cache.size {
+		last := cache.order.Back()
+		cache.order.Remove(last)
+		delete(cache.entries, last.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+func (cache *memoryCache) Delete(_ context.Context, key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if element, b := cache.entries[key]; b {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+	return nil
+}
+
+func (cache *memoryCache) Clear(context.Context) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.entries = map[string]*list.Element{}
+	cache.order.Init()
+	return nil
+}
+
+// namespaceCache 一个命名空间的查询结果缓存
+type namespaceCache struct {
+	namespace string
+	config    CacheConfig
+	cache     Cache
+}
+
+// cacheEntry 缓存的一次查询结果，values 为 mapper 函数除 error 之外的返回值
+type cacheEntry struct {
+	values []reflect.Value
+	rows   int64
+}
+
+// encodedEntry 配置了 CacheCodec 时缓存的数据，Values 为每个返回值单独编码的结果
+type encodedEntry struct {
+	Rows   int64
+	Values [][]byte
+}
+
+// parseCache 解析 mapper 根标签下的 cache 标签，没有配置 cache 时返回 false
+func parseCache(root *etree.Element) (CacheConfig, bool, error) {
+	config := CacheConfig{Eviction: CacheLRU, Size: defaultCacheSize}
+	element := root.SelectElement("cache")
+	if element == nil {
+		return config, false, nil
+	}
+	if attr := element.SelectAttr("eviction"); attr != nil && attr.Value != "" {
+		config.Eviction = strings.ToUpper(attr.Value)
+		if config.Eviction != CacheLRU && config.Eviction != CacheFIFO {
+			return config, false, fmt.Errorf("cache eviction '%s' not supported", attr.Value)
+		}
+	}
+	if attr := element.SelectAttr("ttl"); attr != nil && attr.Value != "" {
+		ttl, err := time.ParseDuration(attr.Value)
+		if err != nil {
+			return config, false, fmt.Errorf("cache ttl '%s',%s", attr.Value, err.Error())
+		}
+		config.TTL = ttl
+	}
+	if attr := element.SelectAttr("size"); attr != nil && attr.Value != "" {
+		size, err := strconv.Atoi(attr.Value)
+		if err != nil || size <= 0 {
+			return config, false, fmt.Errorf("cache size '%s' is not a positive integer", attr.Value)
+		}
+		config.Size = size
+	}
+	if attr := element.SelectAttr("flushOn"); attr != nil {
+		for _, name := range strings.Split(attr.Value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.FlushOn = append(config.FlushOn, name)
+			}
+		}
+	}
+	return config, true, nil
+}
+
+// initCache 在 ScanMappers 中为配置了 cache 标签的命名空间创建缓存
+// 配置了 GoBatis.CacheFactory 时由它创建 Cache，否则按照 eviction 创建进程内的缓存
+func (batis *GoBatis) initCache(namespace string) error {
+	s, b := batis.NameSpaces[namespace]
+	if !b {
+		return nil
+	}
+	config, b, err := parseCache(s.Element)
+	if err != nil || !b {
+		return err
+	}
+	var cache Cache
+	switch {
+	case batis.CacheFactory != nil:
+		cache = batis.CacheFactory(namespace, config)
+	case config.Eviction == CacheFIFO:
+		cache = NewFIFOCache(config.Size)
+	default:
+		cache = NewLRUCache(config.Size)
+	}
+	if cache == nil {
+		return fmt.Errorf("cache factory returned nil cache")
+	}
+	if batis.caches == nil {
+		batis.caches = map[string]*namespaceCache{}
+	}
+	batis.caches[namespace] = &namespaceCache{namespace: namespace, config: config, cache: cache}
+	return nil
+}
+
+// cacheOf 返回 select 语句使用的缓存，命名空间没有配置 cache 或者语句标签配置了 useCache="false" 时返回 nil
+func (batis *GoBatis) cacheOf(id []string) *namespaceCache {
+	cache, b := batis.caches[id[0]]
+	if !b {
+		return nil
+	}
+	element, err := batis.element(id)
+	if err != nil {
+		return nil
+	}
+	if attr := element.SelectAttr("useCache"); attr != nil && strings.EqualFold(attr.Value, "false") {
+		return nil
+	}
+	return cache
+}
+
+// cacheKey 缓存的 key，格式为 前缀:方言:数据源:namespace:id:sql和参数的摘要
+// 默认数据源的名称为 default，多个 GoBatis 共用进程外的缓存并且连接不同的数据库时，需要设置不同的 GoBatis.CachePrefix
+// 分页查询的页码和大小已经在 sql 参数中，page 的 SkipCount 决定是否统计总数，同样参与摘要
+func (batis *GoBatis) cacheKey(inv *Invocation, page *PageRequest) string {
+	hash := sha256.New()
+	hash.Write([]byte(inv.Sql))
+	for _, param := range rawParams(inv.Params) {
+		param = cacheParam(param)
+		fmt.Fprintf(hash, "\x00%T:%v", param, param)
+	}
+	if page != nil && page.SkipCount {
+		hash.Write([]byte("\x00skipCount"))
+	}
+	source := inv.DataSource
+	if source == "" {
+		source = "default"
+	}
+	dialect := ""
+	if inv.Dialect != nil {
+		dialect = inv.Dialect.Name()
+	}
+	return strings.Join([]string{batis.CachePrefix, dialect, source, inv.Namespace, inv.Id, hex.EncodeToString(hash.Sum(nil))}, ":")
+}
+
+// cacheParam 参数按照驱动接收的形式参与摘要，指针取值，driver.Valuer 取 Value，时间去掉单调时钟，相同的值得到相同的 key
+// 驱动不支持的类型只对指针取值
+func cacheParam(param any) any {
+	value, err := driver.DefaultParameterConverter.ConvertValue(param)
+	if err != nil {
+		v := reflect.ValueOf(param)
+		for v.Kind() == reflect.Pointer && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Pointer {
+			return nil
+		}
+		return v.Interface()
+	}
+	if t, b := value.(time.Time); b {
+		return t.Round(0)
+	}
+	return value
+}
+
+// cacheGet 取出缓存的结果并写入 mapper 函数的返回值，返回值为深拷贝的副本，调用方修改返回值(包括切片元素指向的结构体)不会影响缓存
+func (batis *GoBatis) cacheGet(ctx context.Context, cache *namespaceCache, key string, results []reflect.Value) (int64, bool, error) {
+	value, b, err := cache.cache.Get(ctx, key)
+	if err != nil || !b {
+		return 0, false, err
+	}
+	if batis.CacheCodec == nil {
+		entry, b := value.(*cacheEntry)
+		if !b || len(entry.values) != len(results)-1 {
+			return 0, false, nil
+		}
+		for i, v := range entry.values {
+			if v.Type() != results[i].Type() {
+				return 0, false, nil
+			}
+			results[i].Set(cloneValue(v))
+		}
+		return entry.rows, true, nil
+	}
+	data, b := value.([]byte)
+	if !b {
+		return 0, false, fmt.Errorf("cache value of '%s' is %T, not []byte", key, value)
+	}
+	var entry encodedEntry
+	if err = batis.CacheCodec.Decode(data, &entry); err != nil {
+		return 0, false, err
+	}
+	if len(entry.Values) != len(results)-1 {
+		return 0, false, nil
+	}
+	values := make([]reflect.Value, len(entry.Values))
+	for i, data := range entry.Values {
+		values[i] = reflect.New(results[i].Type())
+		if err = batis.CacheCodec.Decode(data, values[i].Interface()); err != nil {
+			return 0, false, err
+		}
+	}
+	for i, v := range values {
+		results[i].Set(v.Elem())
+	}
+	return entry.Rows, true, nil
+}
+
+// cacheSet 缓存 mapper 函数的返回值
+func (batis *GoBatis) cacheSet(ctx context.Context, cache *namespaceCache, key string, rows int64, results []reflect.Value) error {
+	results = results[:len(results)-1]
+	if batis.CacheCodec == nil {
+		entry := &cacheEntry{values: make([]reflect.Value, len(results)), rows: rows}
+		for i, result := range results {
+			entry.values[i] = cloneValue(result)
+		}
+		return cache.cache.Set(ctx, key, entry, cache.config.TTL)
+	}
+	entry := encodedEntry{Rows: rows, Values: make([][]byte, len(results))}
+	for i, result := range results {
+		data, err := batis.CacheCodec.Encode(result.Interface())
+		if err != nil {
+			return err
+		}
+		entry.Values[i] = data
+	}
+	data, err := batis.CacheCodec.Encode(entry)
+	if err != nil {
+		return err
+	}
+	return cache.cache.Set(ctx, key, data, cache.config.TTL)
+}
+
+// cloneValue 深复制切片，数组，map，指针，接口和结构体的导出字段，其他类型直接返回
+// 结构体的未导出字段(例如 time.Time 内部的 *Location)按值复制，查询结果中不应该包含循环引用
+func cloneValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			clone.Index(i).Set(cloneValue(value.Index(i)))
+		}
+		return clone
+	case reflect.Array:
+		clone := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			clone.Index(i).Set(cloneValue(value.Index(i)))
+		}
+		return clone
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.MakeMapWithSize(value.Type(), value.Len())
+		iter := value.MapRange()
+		for iter.Next() {
+			clone.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return clone
+	case reflect.Pointer:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.New(value.Type().Elem())
+		clone.Elem().Set(cloneValue(value.Elem()))
+		return clone
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(cloneValue(value.Elem()))
+		return clone
+	case reflect.Struct:
+		clone := reflect.New(value.Type()).Elem()
+		clone.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if field := clone.Field(i); field.CanSet() {
+				field.Set(cloneValue(value.Field(i)))
+			}
+		}
+		return clone
+	}
+	return value
+}
+
+// flush 清空 namespace 的缓存以及 flushOn 中包含 namespace 的缓存
+func (batis *GoBatis) flush(ctx context.Context, namespace string) {
+	for name, cache := range batis.caches {
+		match := name == namespace
+		for _, on := range cache.config.FlushOn {
+			match = match || on == namespace
+		}
+		if !match {
+			continue
+		}
+		if err := cache.cache.Clear(ctx); err != nil {
+			batis.log(ctx, LogWarn, "sql cache clear failed", Field{Key: "namespace", Value: name}, Field{Key: "error", Value: err})
+		}
+	}
+}
+
+// flushAfterCommit insert，update，delete 执行成功之后清空相关的缓存
+// 语句在 Transaction 开启的事务中执行时，等到事务提交成功之后再清空，事务回滚时不清空
+// 调用方自己管理的事务(显式传入的 *sql.Tx 或者 WithTx)无法感知提交，执行成功之后立即清空
+func (batis *GoBatis) flushAfterCommit(ctx context.Context, namespace string, explicit bool) {
+	if len(batis.caches) == 0 {
+		return
+	}
+	if !batis.afterCommit(ctx, explicit, func() {
+		// 事务的 ctx 可能已经被取消，清空缓存不受影响
+		batis.flush(context.Background(), namespace)
+	}) {
+		batis.flush(ctx, namespace)
+	}
+}
@@ -0,0 +1,147 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		cache Cache
+		keep  string
+		evict string
+	}{
+		{NewLRUCache(2), "a", "b"},
+		{NewFIFOCache(2), "b", "a"},
+	} {
+		c.cache.Set(ctx, "a", 1, 0)
+		c.cache.Set(ctx, "b", 2, 0)
+		c.cache.Get(ctx, "a")
+		c.cache.Set(ctx, "c", 3, 0)
+		if _, b, _ := c.cache.Get(ctx, c.keep); !b {
+			t.Errorf("%T expected %s to be kept", c.cache, c.keep)
+		}
+		if _, b, _ := c.cache.Get(ctx, c.evict); b {
+			t.Errorf("%T expected %s to be evicted", c.cache, c.evict)
+		}
+	}
+	cache := NewLRUCache(2)
+	cache.Set(ctx, "a", 1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, b, _ := cache.Get(ctx, "a"); b {
+		t.Error("expected expired entry")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	batis := &GoBatis{}
+	key := func(params ...any) string {
+		return batis.cacheKey(&Invocation{Namespace: "user", Id: "Get", Dialect: MySQL, Sql: "select * from user where name = ? and created > ?", Params: params}, nil)
+	}
+	a, b, c := "alice", "alice", "bob"
+	now := time.Now()
+	if key(&a, now) != key(&b, now.Round(0)) || key(&a, now) != key("alice", &now) {
+		t.Error("equal values must have the same key")
+	}
+	if key(&a, now) == key(&c, now) {
+		t.Error("different values must have different keys")
+	}
+	if key((*string)(nil), now) != key(nil, now) {
+		t.Error("nil pointer")
+	}
+}
+
+type cacheMapper struct {
+	Page func(ctx context.Context, req PageRequest) (Page[streamUser], error)
+	List func(ctx context.Context) ([]*cacheUser, error)
+}
+
+type cacheUser struct {
+	Id      int64
+	Name    string
+	Profile map[string]any
+	Tags    []string
+	Manager *streamUser
+	Created time.Time
+}
+
+const cacheXml = `
+<mapper namespace="cacheMapper">
+    <cache/>
+    <select id="Page">select id, name from user order by id</select>
+    <select id="List">select id, name from user</select>
+</mapper>`
+
+// TestCachePage SkipCount 不同的分页查询不能共用缓存
+func TestCachePage(t *testing.T) {
+	db := &fakeDB{query: func(_ context.Context, query string, _ []any) (*fakeRows, error) {
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return newRows([]string{"count"}, []driver.Value{int64(7)}), nil
+		}
+		return newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}), nil
+	}}
+	batis := newFakeBatis(t, db, cacheXml)
+	mapper := &cacheMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+
+	page, err := mapper.Page(ctx, PageRequest{Page: 1, Size: 3, SkipCount: true})
+	if err != nil || page.Total != 0 || len(statements(db.history(), "query")) != 1 {
+		t.Fatal(page, err)
+	}
+	page, err = mapper.Page(ctx, PageRequest{Page: 1, Size: 3})
+	if err != nil || page.Total != 7 || page.Pages != 3 {
+		t.Fatalf("SkipCount result returned from cache: %+v %v", page, err)
+	}
+	if queries := statements(db.history(), "query"); len(queries) != 2 {
+		t.Fatalf("%q", queries)
+	}
+	// 相同的分页参数命中缓存
+	if page, err = mapper.Page(ctx, PageRequest{Page: 1, Size: 3}); err != nil || page.Total != 7 || len(db.history()) != 0 {
+		t.Fatal(page, err)
+	}
+	if page, err = mapper.Page(ctx, PageRequest{Page: 1, Size: 3, SkipCount: true}); err != nil || page.Total != 0 || len(db.history()) != 0 {
+		t.Fatal(page, err)
+	}
+}
+
+// TestCacheClone 修改缓存返回的结果，包括指针指向的结构体，map 和切片，不会影响缓存
+func TestCacheClone(t *testing.T) {
+	db := &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) {
+		return newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}), nil
+	}}
+	batis := newFakeBatis(t, db, cacheXml)
+	mapper := &cacheMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+
+	users, err := mapper.List(ctx)
+	if err != nil || len(users) != 1 {
+		t.Fatal(users, err)
+	}
+	// 写入缓存的是执行结果的副本
+	users[0].Name = "changed"
+	cached, err := mapper.List(ctx)
+	if err != nil || cached[0].Name != "a" || len(statements(db.history(), "query")) != 1 {
+		t.Fatal(cached[0], err)
+	}
+	cached[0].Name = "changed"
+	if again, _ := mapper.List(ctx); again[0] == cached[0] || again[0].Name != "a" {
+		t.Error("cached element is shared with the caller")
+	}
+
+	created := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	value := []*cacheUser{{Id: 1, Profile: map[string]any{"tags": []string{"x"}}, Tags: []string{"y"}, Manager: &streamUser{Name: "m"}, Created: created}}
+	clone := cloneValue(reflect.ValueOf(value)).Interface().([]*cacheUser)
+	clone[0].Profile["tags"].([]string)[0] = "changed"
+	clone[0].Tags[0] = "changed"
+	clone[0].Manager.Name = "changed"
+	if value[0].Profile["tags"].([]string)[0] != "x" || value[0].Tags[0] != "y" || value[0].Manager.Name != "m" || !clone[0].Created.Equal(created) {
+		t.Errorf("%+v", value[0])
+	}
+}
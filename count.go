@@ -0,0 +1,175 @@
+package gobatis
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// countQuery 生成查询语句对应的总数统计语句
+// 查询语句配置了 countId 属性时，使用同一个命名空间下 countId 指定的语句和当前上下文数据解析统计语句，
+// 否则通过 countSql 从查询语句模板生成，limit 表示是否需要执行统计(手写的统计语句总是需要执行)
+func (batis *GoBatis) countQuery(id []string, ctx any, templateSql string, params []any) (string, []any, bool, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return "", nil, false, err
+	}
+	attr := element.SelectAttr("countId")
+	if attr == nil || attr.Value == "" {
+		sql, args, limit := countSql(templateSql, params)
+		return sql, args, limit, nil
+	}
+	tag, sql, args, err := batis.get([]string{id[0], attr.Value}, ctx)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("countId '%s',%s", attr.Value, err.Error())
+	}
+	if tag != Select {
+		return "", nil, false, fmt.Errorf("countId '%s' is not a select statement", attr.Value)
+	}
+	return sql, args, true, nil
+}
+
+// countSql 根据查询语句模板生成统计总数的 sql
+// 原查询语句去掉末尾的 ORDER BY / LIMIT / OFFSET / FETCH / FOR UPDATE 之后作为子查询，包装成 SELECT COUNT(*) FROM (...) t，
+// 被去掉部分中的 ? 占位符对应的参数一并从 params 末尾移除，其余参数和原查询保持一致
+// limit 表示原查询语句是否存在分页
+func countSql(templateSql string, params []any) (sql string, args []any, limit bool) {
+	body, tail, limit := splitTail(templateSql)
+	n := 0
+	for _, token := range sqlTokenIndex(tail, true) {
+		if token.text == "?" {
+			n++
+		}
+	}
+	if n > len(params) {
+		n = len(params)
+	}
+	args = params[:len(params)-n]
+	return "SELECT COUNT(*) FROM (" + strings.TrimSpace(body) + ") t", args, limit
+}
+
+// splitTail 把查询语句拆分为主体和末尾的排序分页部分，limit 表示末尾是否包含分页子句
+// 只识别最外层(不在括号，字符串，注释中)并且位于最后一个 UNION/INTERSECT/EXCEPT 之后的子句
+func splitTail(templateSql string) (string, string, bool) {
+	tail := -1
+	limit := false
+	tokens := sqlTokenIndex(templateSql, false)
+	for i, token := range tokens {
+		word := strings.ToLower(token.text)
+		next := ""
+		if i+1 < len(tokens) {
+			next = strings.ToLower(tokens[i+1].text)
+		}
+		switch word {
+		case "union", "intersect", "except":
+			tail, limit = -1, false
+			continue
+		}
+		paging := word == "limit" && isBound(next) ||
+			word == "offset" && isBound(next) ||
+			word == "fetch" && (next == "first" || next == "next")
+		limit = limit || paging
+		if tail == -1 && (paging || word == "order" && next == "by" || word == "for" && next == "update") {
+			tail = token.index
+		}
+	}
+	if tail == -1 {
+		return templateSql, "", false
+	}
+	return templateSql[:tail], templateSql[tail:], limit
+}
+
+// isBound 分页参数只能是数字或者占位符
+func isBound(token string) bool {
+	if token == "?" {
+		return true
+	}
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+type sqlToken struct {
+	text  string
+	index int
+}
+
+// sqlTokenIndex 对 sql 做简单的词法切分，返回单词，? 占位符和其他符号以及它们在 s 中的位置
+// 字符串，引用标识符，注释都会被跳过，nested 为 false 时括号内的内容也会被跳过
+func sqlTokenIndex(s string, nested bool) []sqlToken {
+	tokens := make([]sqlToken, 0)
+	depth := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuote(s, i, c)
+		case c == '-' && i+1 < len(s) && s[i+1] == '-':
+			if end := strings.IndexByte(s[i:], '\n'); end != -1 {
+				i += end + 1
+			} else {
+				i = len(s)
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			if end := strings.Index(s[i+2:], "*/"); end != -1 {
+				i += end + 4
+			} else {
+				i = len(s)
+			}
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case c == '?':
+			if depth == 0 || nested {
+				tokens = append(tokens, sqlToken{text: "?", index: i})
+			}
+			i++
+		case isWord(c):
+			star := i
+			for i < len(s) && isWord(s[i]) {
+				i++
+			}
+			if depth == 0 || nested {
+				tokens = append(tokens, sqlToken{text: s[star:i], index: star})
+			}
+		default:
+			// 其他符号单独作为一个 token，空白字符跳过
+			if c > ' ' && (depth == 0 || nested) {
+				tokens = append(tokens, sqlToken{text: s[i : i+1], index: i})
+			}
+			i++
+		}
+	}
+	return tokens
+}
+
+// skipQuote 跳过引号包裹的内容，返回结束引号之后的位置，连续两个引号视为转义
+func skipQuote(s string, i int, quote byte) int {
+	for j := i + 1; j < len(s); j++ {
+		if s[j] == '\\' && quote != '`' {
+			j++
+			continue
+		}
+		if s[j] == quote {
+			if j+1 < len(s) && s[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+func isWord(c byte) bool {
+	return c == '_' || c == '$' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
@@ -0,0 +1,173 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountSql(t *testing.T) {
+	cases := []struct {
+		sql    string
+		params []any
+		count  string
+		args   []any
+		limit  bool
+	}{
+		{
+			sql:    "select * from student where age > ? limit ? offset ?",
+			params: []any{18, 10, 0},
+			count:  "SELECT COUNT(*) FROM (select * from student where age > ?) t",
+			args:   []any{18},
+			limit:  true,
+		},
+		{
+			sql:    "  /* list */ SELECT name, (select max(a) from b limit 1) AS m FROM t ORDER BY FIELD(id, ?, ?) LIMIT 10",
+			params: []any{1, 2},
+			count:  "SELECT COUNT(*) FROM (/* list */ SELECT name, (select max(a) from b limit 1) AS m FROM t) t",
+			args:   []any{},
+			limit:  true,
+		},
+		{
+			sql:    "select distinct name from a where note = 'order by' group by name",
+			params: nil,
+			count:  "SELECT COUNT(*) FROM (select distinct name from a where note = 'order by' group by name) t",
+			args:   nil,
+			limit:  false,
+		},
+		{
+			sql:    "select id from a order by id union select id from b where x = ? order by id limit ?",
+			params: []any{1, 5},
+			count:  "SELECT COUNT(*) FROM (select id from a order by id union select id from b where x = ?) t",
+			args:   []any{1},
+			limit:  true,
+		},
+		{
+			sql:    "select offset, `limit` from t where offset > ? order by offset",
+			params: []any{3},
+			count:  "SELECT COUNT(*) FROM (select offset, `limit` from t where offset > ?) t",
+			args:   []any{3},
+			limit:  false,
+		},
+		{
+			sql:    "select * from t order by id offset ? rows fetch next ? rows only",
+			params: []any{0, 10},
+			count:  "SELECT COUNT(*) FROM (select * from t) t",
+			args:   []any{},
+			limit:  true,
+		},
+	}
+	for _, c := range cases {
+		count, args, limit := countSql(c.sql, c.params)
+		if count != c.count {
+			t.Errorf("countSql(%q) = %q, want %q", c.sql, count, c.count)
+		}
+		if len(args) != len(c.args) || (len(args) > 0 && !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("countSql(%q) args = %v, want %v", c.sql, args, c.args)
+		}
+		if limit != c.limit {
+			t.Errorf("countSql(%q) limit = %v, want %v", c.sql, limit, c.limit)
+		}
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	batis := &GoBatis{Dialect: MySQL}
+	req := &PageRequest{Page: 2, Size: 10, Sort: "name desc"}
+	_, sql, params, err := batis.pageQuery(req, "select * from t where a = ? order by field(id, ?)", []any{1, 2})
+	if err != nil || sql != "select * from t where a = ? ORDER BY name desc LIMIT ? OFFSET ?" || !reflect.DeepEqual(params, []any{1, int64(10), int64(10)}) {
+		t.Error(sql, params, err)
+	}
+	_, sql, _, err = batis.pageQuery(&PageRequest{Page: 1, Size: 5}, "select * from t order by id", nil)
+	if err != nil || sql != "select * from t order by id LIMIT ? OFFSET ?" {
+		t.Error(sql, err)
+	}
+	for _, template := range []string{"select * from t limit 5", "select * from t order by id fetch first 5 rows only", "select * from t for update"} {
+		if _, _, _, err = batis.pageQuery(&PageRequest{Page: 1, Size: 5}, template, nil); err == nil {
+			t.Errorf("%s: expected error", template)
+		}
+	}
+	sql, _ = SQLServer.Page("select row_number() over(order by id) n, (select top 1 x from y order by x) m from t", 0, 5)
+	if sql != "select row_number() over(order by id) n, (select top 1 x from y order by x) m from t ORDER BY (SELECT NULL) OFFSET ? ROWS FETCH NEXT ? ROWS ONLY" {
+		t.Error(sql)
+	}
+}
+
+type pageMapper struct {
+	Page func(ctx context.Context, arg map[string]any, req PageRequest) (Page[streamUser], error)
+	List func(ctx context.Context, req *PageRequest) ([]streamUser, int64, error)
+}
+
+func TestPageStatement(t *testing.T) {
+	db := &fakeDB{query: func(_ context.Context, query string, _ []any) (*fakeRows, error) {
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return newRows([]string{"count"}, []driver.Value{int64(7)}), nil
+		}
+		return newRows([]string{"id", "name"}, []driver.Value{int64(4), "d"}, []driver.Value{int64(5), "e"}, []driver.Value{int64(6), "f"}), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="pageMapper">
+    <select id="Page">select id, name from user where age > {age} order by id</select>
+    <select id="List">select id, name from user</select>
+</mapper>`)
+	mapper := &pageMapper{}
+	batis.ScanMappers(mapper)
+
+	page, err := mapper.Page(context.Background(), map[string]any{"age": 18}, PageRequest{Page: 2, Size: 3, Sort: "name desc"})
+	if err != nil || len(page.Items) != 3 || page.Items[0].Name != "d" || page.Total != 7 || page.Pages != 3 || page.Page != 2 || page.Size != 3 {
+		t.Fatalf("%+v %v", page, err)
+	}
+	want := []string{
+		"query select id, name from user where age > ? ORDER BY name desc LIMIT ? OFFSET ? [18 3 3]",
+		"query SELECT COUNT(*) FROM (select id, name from user where age > ?) t [18]",
+	}
+	if got := db.history(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+
+	page, err = mapper.Page(context.Background(), map[string]any{"age": 18}, PageRequest{Page: 1, Size: 3, SkipCount: true})
+	if err != nil || len(page.Items) != 3 || page.Total != 0 || page.Pages != 0 {
+		t.Fatalf("%+v %v", page, err)
+	}
+	if queries := statements(db.history(), "query"); len(queries) != 1 {
+		t.Fatalf("SkipCount: %q", queries)
+	}
+
+	list, total, err := mapper.List(context.Background(), &PageRequest{Page: 1, Size: 3})
+	if err != nil || len(list) != 3 || total != 7 {
+		t.Fatal(list, total, err)
+	}
+}
+
+// TestPostgresPage 驱动只接受 $n 占位符，分页和统计语句在交给驱动之前都要完成替换
+func TestPostgresPage(t *testing.T) {
+	db := &fakeDB{driver: pgxDriver{}, query: func(_ context.Context, query string, _ []any) (*fakeRows, error) {
+		if strings.Contains(query, "?") {
+			return nil, errors.New("syntax error at or near \"?\"")
+		}
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return newRows([]string{"count"}, []driver.Value{int64(7)}), nil
+		}
+		return newRows([]string{"id", "name"}, []driver.Value{int64(4), "d"}), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="pageMapper">
+    <select id="Page">select id, name from user where age > {age} order by id</select>
+</mapper>`)
+	mapper := &pageMapper{}
+	batis.ScanMappers(mapper)
+	page, err := mapper.Page(context.Background(), map[string]any{"age": 18}, PageRequest{Page: 2, Size: 3})
+	if err != nil || len(page.Items) != 1 || page.Total != 7 {
+		t.Fatalf("%+v %v", page, err)
+	}
+	want := []string{
+		"query select id, name from user where age > $1 order by id LIMIT $2 OFFSET $3 [18 3 3]",
+		"query SELECT COUNT(*) FROM (select id, name from user where age > $1) t [18]",
+	}
+	if got := db.history(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package gobatis
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// dataSource 通过 AddDataSource 注册的命名数据源
+type dataSource struct {
+	db       *sql.DB
+	replicas []*sql.DB
+	dialect  Dialect
+	balancer Balancer
+}
+
+// AddDataSource 注册一个命名数据源，db 为主库，replicas 为只读从库，方言根据驱动自动识别
+// mapper 文件根标签 <mapper datasource="name">，mapper 函数字段标签 datasource:"name" 或者语句标签的 datasource 属性
+// 可以指定在该数据源上执行，没有指定时使用 New 创建时传入的默认数据源
+func (batis *GoBatis) AddDataSource(name string, db *sql.DB, replicas ...*sql.DB) {
+	if name == "" {
+		Panic("data source name is empty")
+	}
+	if db == nil {
+		Panic("data source '" + name + "' db nil")
+	}
+	if err := db.Ping(); err != nil {
+		Panic(err)
+	}
+	for _, replica := range replicas {
+		if replica == nil {
+			Panic("data source '" + name + "' replica db nil")
+		}
+		if err := replica.Ping(); err != nil {
+			Panic(err)
+		}
+	}
+	if batis.dataSources == nil {
+		batis.dataSources = map[string]*dataSource{}
+	}
+	batis.dataSources[name] = &dataSource{db: db, replicas: replicas, dialect: dialectOf(db), balancer: &RoundRobin{}}
+}
+
+// SetBalancer 设置 name 数据源选择从库的策略，AddDataSource 注册的数据源默认使用 RoundRobin 轮询，
+// name 为空时设置默认数据源的 GoBatis.Balancer，需要在 AddDataSource 之后调用
+func (batis *GoBatis) SetBalancer(name string, balancer Balancer) {
+	if name == "" {
+		batis.Balancer = balancer
+		return
+	}
+	source, b := batis.dataSources[name]
+	if !b {
+		Panic("data source '" + name + "' not found")
+	}
+	source.balancer = balancer
+}
+
+// on 返回在 name 数据源上执行的 GoBatis，name 为空时返回默认数据源
+// 返回值是 batis 的浅拷贝，只替换了数据库，从库，方言和负载均衡策略，其他配置和 mapper 文件共用
+func (batis *GoBatis) on(name string) (*GoBatis, error) {
+	root := batis
+	if batis.root != nil {
+		root = batis.root
+	}
+	if name == "" {
+		return root, nil
+	}
+	source, b := root.dataSources[name]
+	if !b {
+		return nil, fmt.Errorf("data source '%s' not found", name)
+	}
+	bind := *root
+	bind.root = root
+	bind.source = name
+	bind.db = reflect.ValueOf(source.db)
+	bind.replicas = source.replicas
+	bind.Dialect = source.dialect
+	bind.Balancer = source.balancer
+	return &bind, nil
+}
+
+// bind 返回执行 id 对应语句的数据源
+// 优先级从高到低依次为 语句标签的 datasource 属性，mapper 函数字段的 datasource 标签，mapper 文件根标签的 datasource 属性
+func (batis *GoBatis) bind(id []string) (*GoBatis, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return nil, err
+	}
+	if attr := element.SelectAttr("datasource"); attr != nil && attr.Value != "" {
+		return batis.on(attr.Value)
+	}
+	if name := batis.dataSourceTags[strings.Join(id, ".")]; name != "" {
+		return batis.on(name)
+	}
+	if attr := batis.NameSpaces[id[0]].Element.SelectAttr("datasource"); attr != nil && attr.Value != "" {
+		return batis.on(attr.Value)
+	}
+	return batis.on("")
+}
@@ -0,0 +1,123 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bindMapper struct {
+	Namespace func(ctx context.Context) ([]streamUser, error)
+	Tagged    func(ctx context.Context) ([]streamUser, error) `datasource:"b"`
+	Statement func(ctx context.Context) ([]streamUser, error) `datasource:"b"`
+	Add       func(ctx context.Context, row map[string]any) (int64, error)
+	AddB      func(ctx context.Context, row map[string]any) (int64, error) `datasource:"b"`
+	Missing   func(ctx context.Context) ([]streamUser, error)
+}
+
+func TestDataSourceBind(t *testing.T) {
+	root, a, b := &fakeDB{}, &fakeDB{}, &fakeDB{}
+	batis := newFakeBatis(t, root)
+	batis.AddDataSource("a", a.open())
+	batis.AddDataSource("b", b.open())
+	loadMapper(t, batis, `
+<mapper namespace="bindMapper" datasource="a">
+    <select id="Namespace">select id, name from user</select>
+    <select id="Tagged">select id, name from user</select>
+    <select id="Statement" datasource="a">select id, name from user</select>
+    <insert id="Add">insert into user (name) values ({name})</insert>
+    <insert id="AddB">insert into user (name) values ({name})</insert>
+    <select id="Missing" datasource="c">select id, name from user</select>
+</mapper>`)
+	mapper := &bindMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+	// 语句标签的 datasource 属性 > mapper 函数字段的 datasource 标签 > mapper 文件根标签的 datasource 属性
+	cases := []struct {
+		name string
+		call func(context.Context) ([]streamUser, error)
+		db   *fakeDB
+	}{
+		{"namespace", mapper.Namespace, a},
+		{"tag", mapper.Tagged, b},
+		{"statement", mapper.Statement, a},
+	}
+	for _, c := range cases {
+		if _, err := c.call(ctx); err != nil {
+			t.Fatal(c.name, err)
+		}
+		for _, db := range []*fakeDB{root, a, b} {
+			if n := len(statements(db.history(), "query")); (db == c.db) != (n == 1) {
+				t.Errorf("%s: %d queries on wrong data source", c.name, n)
+			}
+		}
+	}
+	if _, err := mapper.Missing(ctx); err == nil || !strings.Contains(err.Error(), "data source 'c' not found") {
+		t.Error(err)
+	}
+
+	// TransactionOn 只有在该数据源上执行的语句加入事务
+	err := batis.TransactionOn(ctx, "a", nil, func(ctx context.Context) error {
+		if _, err := mapper.Add(ctx, map[string]any{"name": "a"}); err != nil {
+			return err
+		}
+		_, err := mapper.AddB(ctx, map[string]any{"name": "b"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log := a.history(); !reflect.DeepEqual(log, []string{"begin", "exec insert into user (name) values (?) [a]", "commit"}) {
+		t.Errorf("%q", log)
+	}
+	if log := b.history(); !reflect.DeepEqual(log, []string{"begin", "exec insert into user (name) values (?) [b]", "commit"}) {
+		t.Errorf("b runs in its own implicit transaction: %q", log)
+	}
+	if log := root.history(); len(log) != 0 {
+		t.Errorf("%q", log)
+	}
+	if err = batis.TransactionOn(ctx, "c", nil, func(context.Context) error { return nil }); err == nil {
+		t.Error("expected error for unknown data source")
+	}
+}
+
+// lastReplica 始终选择最后一个从库
+type lastReplica struct{}
+
+func (lastReplica) Pick(replicas []*sql.DB) *sql.DB {
+	return replicas[len(replicas)-1]
+}
+
+func TestSetBalancer(t *testing.T) {
+	root, rootReplica, a, first, last := &fakeDB{}, &fakeDB{}, &fakeDB{}, &fakeDB{}, &fakeDB{}
+	batis := New(root.open(), rootReplica.open())
+	batis.Logger = NopLogger{}
+	batis.AddDataSource("a", a.open(), first.open(), last.open())
+	batis.SetBalancer("a", lastReplica{})
+	loadMapper(t, batis, `
+<mapper namespace="bindMapper">
+    <select id="Namespace" datasource="a">select id, name from user</select>
+</mapper>`)
+	mapper := &bindMapper{}
+	batis.ScanMappers(mapper)
+	for i := 0; i < 3; i++ {
+		if _, err := mapper.Namespace(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(statements(last.history(), "query")); n != 3 || len(first.history()) != 0 || len(a.history()) != 0 {
+		t.Errorf("last %d, first %q, primary %q", n, first.history(), a.history())
+	}
+	// 默认数据源的策略不受影响
+	if _, ok := batis.Balancer.(*RoundRobin); !ok {
+		t.Errorf("%T", batis.Balancer)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unknown data source")
+		}
+	}()
+	batis.SetBalancer("c", lastReplica{})
+}
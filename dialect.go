@@ -0,0 +1,334 @@
+package gobatis
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect 数据库方言，处理不同数据库之间的 sql 语法差异
+// GoBatis 在 New 的时候会根据驱动自动选择，也可以通过 GoBatis.Dialect 手动指定
+type Dialect interface {
+	// Name 方言名称
+	Name() string
+	// Page 给查询语句追加分页，返回追加后的 sql 和分页对应的参数
+	// offset 跳过的记录数，limit 查询的记录数
+	Page(sql string, offset, limit int64) (string, []any)
+	// Quote 把字符串转义为 sql 字符串字面量，仅用于渲染日志中的调试 sql
+	Quote(s string) string
+	// Bytes 把二进制数据渲染为 sql 字面量，仅用于渲染日志中的调试 sql
+	Bytes(b []byte) string
+	// Bool 把布尔值渲染为 sql 字面量，仅用于渲染日志中的调试 sql
+	Bool(b bool) string
+	// Returning 给 insert 语句追加返回主键的子句，不支持的数据库返回 false，通过 LastInsertId 获取主键
+	Returning(sql, column string) (string, bool)
+	// InsertIds 根据 LastInsertId 和影响行数推算多行插入时每一行的主键
+	InsertIds(lastId, rows int64) []int64
+	// Savepoint 返回创建，回滚到，释放保存点的语句，不支持保存点的数据库返回空字符串，嵌套事务将直接加入外层事务
+	Savepoint(name string) (save, rollback, release string)
+	// Rebind 把 sql 中的 ? 占位符替换为驱动使用的占位符，在语句交给驱动之前调用，拦截器和日志中的 sql 仍然使用 ?
+	Rebind(sql string) string
+}
+
+var (
+	MySQL      Dialect = mysqlDialect{}
+	PostgreSQL Dialect = postgresDialect{}
+	SQLite     Dialect = sqliteDialect{}
+	SQLServer  Dialect = sqlserverDialect{}
+	Oracle     Dialect = oracleDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Page(sql string, offset, limit int64) (string, []any) {
+	return sql + " LIMIT ? OFFSET ?", []any{limit, offset}
+}
+
+// Quote MySQL 默认开启反斜杠转义
+func (mysqlDialect) Quote(s string) string {
+	buf := strings.Builder{}
+	buf.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case 0x1a:
+			buf.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+func (mysqlDialect) Bytes(b []byte) string {
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+func (mysqlDialect) Bool(b bool) string {
+	return boolLiteral(b, "TRUE", "FALSE")
+}
+
+func (mysqlDialect) Returning(sql, column string) (string, bool) {
+	return sql, false
+}
+
+// InsertIds MySQL 多行插入时 LastInsertId 返回的是第一行的主键
+func (mysqlDialect) InsertIds(lastId, rows int64) []int64 {
+	return consecutiveIds(lastId, rows)
+}
+
+func (mysqlDialect) Savepoint(name string) (string, string, string) {
+	return standardSavepoint(name)
+}
+
+func (mysqlDialect) Rebind(sql string) string {
+	return sql
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Page(sql string, offset, limit int64) (string, []any) {
+	return sql + " LIMIT ? OFFSET ?", []any{limit, offset}
+}
+
+func (postgresDialect) Quote(s string) string {
+	return standardQuote(s)
+}
+
+func (postgresDialect) Bytes(b []byte) string {
+	return "'\\x" + hex.EncodeToString(b) + "'::bytea"
+}
+
+func (postgresDialect) Bool(b bool) string {
+	return boolLiteral(b, "TRUE", "FALSE")
+}
+
+func (postgresDialect) Returning(sql, column string) (string, bool) {
+	return sql + " RETURNING " + column, true
+}
+
+func (postgresDialect) InsertIds(lastId, rows int64) []int64 {
+	return consecutiveIds(lastId, rows)
+}
+
+func (postgresDialect) Savepoint(name string) (string, string, string) {
+	return standardSavepoint(name)
+}
+
+// Rebind lib/pq 和 pgx 使用 $1，$2 形式的占位符
+func (postgresDialect) Rebind(sql string) string {
+	return rebind(sql, "$")
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Page(sql string, offset, limit int64) (string, []any) {
+	return sql + " LIMIT ? OFFSET ?", []any{limit, offset}
+}
+
+func (sqliteDialect) Quote(s string) string {
+	return standardQuote(s)
+}
+
+func (sqliteDialect) Bytes(b []byte) string {
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+func (sqliteDialect) Bool(b bool) string {
+	return boolLiteral(b, "1", "0")
+}
+
+func (sqliteDialect) Returning(sql, column string) (string, bool) {
+	return sql, false
+}
+
+// InsertIds SQLite 多行插入时 LastInsertId 返回的是最后一行的主键
+func (sqliteDialect) InsertIds(lastId, rows int64) []int64 {
+	return consecutiveIds(lastId-rows+1, rows)
+}
+
+func (sqliteDialect) Savepoint(name string) (string, string, string) {
+	return standardSavepoint(name)
+}
+
+func (sqliteDialect) Rebind(sql string) string {
+	return sql
+}
+
+type sqlserverDialect struct{}
+
+func (sqlserverDialect) Name() string { return "sqlserver" }
+
+// Page SQL Server 的 OFFSET FETCH 必须跟在 ORDER BY 之后
+func (sqlserverDialect) Page(sql string, offset, limit int64) (string, []any) {
+	if !hasOrderBy(sql) {
+		sql += " ORDER BY (SELECT NULL)"
+	}
+	return sql + " OFFSET ? ROWS FETCH NEXT ? ROWS ONLY", []any{offset, limit}
+}
+
+func (sqlserverDialect) Quote(s string) string {
+	return "N" + standardQuote(s)
+}
+
+func (sqlserverDialect) Bytes(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+func (sqlserverDialect) Bool(b bool) string {
+	return boolLiteral(b, "1", "0")
+}
+
+// Returning SQL Server 通过 OUTPUT INSERTED 子句返回主键，子句位于字段列表之后，VALUES 或者 SELECT 之前
+// 驱动不支持 LastInsertId，因此 insert 语句必须包含 VALUES，SELECT 或者 DEFAULT VALUES，否则返回 false
+func (sqlserverDialect) Returning(sql, column string) (string, bool) {
+	for _, token := range sqlTokenIndex(sql, false) {
+		switch strings.ToLower(token.text) {
+		case "values", "select", "default":
+			return sql[:token.index] + "OUTPUT INSERTED." + column + " " + sql[token.index:], true
+		}
+	}
+	return sql, false
+}
+
+// InsertIds SQL Server 的主键通过 OUTPUT INSERTED 获取，仅在驱动返回 LastInsertId 时使用，按照最后一行的主键推算
+func (sqlserverDialect) InsertIds(lastId, rows int64) []int64 {
+	return consecutiveIds(lastId-rows+1, rows)
+}
+
+// Savepoint SQL Server 的保存点不需要释放
+func (sqlserverDialect) Savepoint(name string) (string, string, string) {
+	return "SAVE TRANSACTION " + name, "ROLLBACK TRANSACTION " + name, ""
+}
+
+// Rebind go-mssqldb 使用 @p1，@p2 形式的占位符
+func (sqlserverDialect) Rebind(sql string) string {
+	return rebind(sql, "@p")
+}
+
+type oracleDialect struct{}
+
+func (oracleDialect) Name() string { return "oracle" }
+
+// Page Oracle 12c 开始支持 OFFSET FETCH 语法
+func (oracleDialect) Page(sql string, offset, limit int64) (string, []any) {
+	return sql + " OFFSET ? ROWS FETCH NEXT ? ROWS ONLY", []any{offset, limit}
+}
+
+func (oracleDialect) Quote(s string) string {
+	return standardQuote(s)
+}
+
+func (oracleDialect) Bytes(b []byte) string {
+	return "HEXTORAW('" + hex.EncodeToString(b) + "')"
+}
+
+func (oracleDialect) Bool(b bool) string {
+	return boolLiteral(b, "1", "0")
+}
+
+func (oracleDialect) Returning(sql, column string) (string, bool) {
+	return sql, false
+}
+
+// InsertIds Oracle 驱动不支持 LastInsertId，RETURNING INTO 需要输出参数，useGeneratedKeys 由 keyConfig 提前拒绝
+// LastInsertId 按照最后一行的主键推算，和 SQL Server 保持一致
+func (oracleDialect) InsertIds(lastId, rows int64) []int64 {
+	return consecutiveIds(lastId-rows+1, rows)
+}
+
+// Savepoint Oracle 的保存点不需要释放
+func (oracleDialect) Savepoint(name string) (string, string, string) {
+	return "SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name, ""
+}
+
+// Rebind godror 和 go-ora 使用 :1，:2 形式的占位符
+func (oracleDialect) Rebind(sql string) string {
+	return rebind(sql, ":")
+}
+
+func standardSavepoint(name string) (string, string, string) {
+	return "SAVEPOINT " + name, "ROLLBACK TO SAVEPOINT " + name, "RELEASE SAVEPOINT " + name
+}
+
+// rebind 按照出现的顺序把 ? 占位符替换为 prefix 加序号，字符串，引用标识符和注释中的 ? 保持不变
+func rebind(sql, prefix string) string {
+	buf := strings.Builder{}
+	last, n := 0, 0
+	for _, token := range sqlTokenIndex(sql, true) {
+		if token.text != "?" {
+			continue
+		}
+		n++
+		buf.WriteString(sql[last:token.index])
+		buf.WriteString(prefix)
+		buf.WriteString(strconv.Itoa(n))
+		last = token.index + 1
+	}
+	if n == 0 {
+		return sql
+	}
+	buf.WriteString(sql[last:])
+	return buf.String()
+}
+
+// consecutiveIds 自增主键连续分配时，从 first 开始推算 rows 个主键
+func consecutiveIds(first, rows int64) []int64 {
+	if rows <= 0 {
+		return nil
+	}
+	ids := make([]int64, rows)
+	for i := range ids {
+		ids[i] = first + int64(i)
+	}
+	return ids
+}
+
+// standardQuote 标准 sql 字符串字面量，单引号通过连续两个单引号转义
+func standardQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func boolLiteral(b bool, t, f string) string {
+	if b {
+		return t
+	}
+	return f
+}
+
+// dialectOf 根据驱动的包路径选择方言，无法识别的驱动默认使用 MySQL
+func dialectOf(db *sql.DB) Dialect {
+	driver := reflect.TypeOf(db.Driver())
+	if driver.Kind() == reflect.Pointer {
+		driver = driver.Elem()
+	}
+	path := strings.ToLower(driver.PkgPath() + "." + driver.Name())
+	switch {
+	case strings.Contains(path, "sqlite"):
+		return SQLite
+	case strings.Contains(path, "postgres"), strings.Contains(path, "pgx"), strings.HasSuffix(driver.PkgPath(), "/pq"):
+		return PostgreSQL
+	case strings.Contains(path, "mssql"), strings.Contains(path, "sqlserver"):
+		return SQLServer
+	case strings.Contains(path, "godror"), strings.Contains(path, "go-ora"), strings.Contains(path, "oracle"):
+		return Oracle
+	}
+	return MySQL
+}
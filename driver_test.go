@@ -0,0 +1,252 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+// fakeDB 测试用的 database/sql 驱动，语句交给 query，exec 处理，事务和语句的执行过程按顺序记录在 log 中
+type fakeDB struct {
+	mu  sync.Mutex
+	log []string
+	// query 返回查询的结果集，nil 时返回空结果集
+	query func(ctx context.Context, query string, args []any) (*fakeRows, error)
+	// exec 返回修改的结果，nil 时影响 1 行
+	exec func(ctx context.Context, query string, args []any) (driver.Result, error)
+	// commit 返回提交事务的错误，nil 时提交成功
+	commit func() error
+	// closed 已经关闭的结果集数量
+	closed int
+	// driver 为 sql.DB.Driver 返回的驱动，用于方言识别，nil 时为 fakeDriver
+	driver driver.Driver
+}
+
+func (db *fakeDB) record(format string, v ...any) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, fmt.Sprintf(format, v...))
+}
+
+// history 返回执行记录并清空
+func (db *fakeDB) history() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	log := db.log
+	db.log = nil
+	return log
+}
+
+func (db *fakeDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{db: db})
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, driver.ErrBadConn
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	if c.db.driver != nil {
+		return c.db.driver
+	}
+	return fakeDriver{}
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.ReadOnly {
+		c.db.record("begin read-only")
+	} else {
+		c.db.record("begin")
+	}
+	return fakeTx{db: c.db}, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := namedValues(args)
+	c.db.record("query %s %v", query, values)
+	if c.db.query == nil {
+		return &fakeRows{db: c.db}, nil
+	}
+	rows, err := c.db.query(ctx, query, values)
+	if err != nil {
+		return nil, err
+	}
+	rows.db = c.db
+	return rows, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := namedValues(args)
+	c.db.record("exec %s %v", query, values)
+	if c.db.exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return c.db.exec(ctx, query, values)
+}
+
+func namedValues(args []driver.NamedValue) []any {
+	values := make([]any, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("commit")
+	if tx.db.commit == nil {
+		return nil
+	}
+	return tx.db.commit()
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("rollback")
+	return nil
+}
+
+// fakeRows 预设的结果集，next 记录调用方读取了多少行
+type fakeRows struct {
+	db      *fakeDB
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func newRows(columns []string, values ...[]driver.Value) *fakeRows {
+	return &fakeRows{columns: columns, values: values}
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	r.db.mu.Lock()
+	r.db.closed++
+	r.db.mu.Unlock()
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+// fakeResult 修改语句的结果
+type fakeResult struct {
+	rows, id int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.id, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+// newFakeBatis 创建连接 fakeDB 的 GoBatis，加载 mappers 中的 xml
+func newFakeBatis(t *testing.T, db *fakeDB, mappers ...string) *GoBatis {
+	t.Helper()
+	batis := New(db.open())
+	batis.Logger = NopLogger{}
+	for _, mapper := range mappers {
+		loadMapper(t, batis, mapper)
+	}
+	return batis
+}
+
+// loadMapper 从字符串中加载 mapper xml
+func loadMapper(t *testing.T, batis *GoBatis, mapper string) {
+	t.Helper()
+	document := etree.NewDocument()
+	if err := document.ReadFromString(strings.TrimSpace(mapper)); err != nil {
+		t.Fatal(err)
+	}
+	root := document.Root()
+	s := NewSql(root)
+	s.LoadSqlElement()
+	batis.NameSpaces[root.SelectAttrValue("namespace", "")] = s
+}
+
+// statements 返回执行记录中以 prefix 开头的记录
+func statements(log []string, prefix string) []string {
+	var list []string
+	for _, line := range log {
+		if strings.HasPrefix(line, prefix) {
+			list = append(list, line)
+		}
+	}
+	return list
+}
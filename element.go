@@ -10,26 +10,47 @@ import (
 	"strings"
 )
 
+// StatementElement 解析语句标签的文本，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func StatementElement(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
-	analysisTemplate, t, param, err := AnalysisTemplate(template, ctx)
-	if err != nil {
-		return "", "", param, fmt.Errorf("%s,%s,%s", element.Tag, element.SelectAttr("id").Value, err.Error())
-	}
-	return analysisTemplate, t, param, nil
+	return debugElement(statementElement(element, template, ctx))
 }
 
+// ForElement 解析 for 标签，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func ForElement(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
-	return forElement(element, template, ctx)
+	return debugElement(forElement(element, template, ctx))
 }
 
+// IfElement 解析 if 标签，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func IfElement(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
-	return ifElement(element, template, ctx)
+	return debugElement(ifElement(element, template, ctx))
 }
 
-func forElement(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
+// debugElement 兼容旧的解析函数，在 sql 模板和参数之前加上按照 MySQL 方言渲染的调试 sql
+func debugElement(template string, params []any, err error) (string, string, []any, error) {
+	if err != nil {
+		return "", template, params, err
+	}
+	return Render(MySQL, template, params), template, params, nil
+}
+
+func statementElement(element *etree.Element, template string, ctx map[string]any) (string, []any, error) {
+	t, param, err := analysisTemplate(template, ctx)
+	if err != nil {
+		return "", param, fmt.Errorf("%s,%s,%s", element.Tag, element.SelectAttr("id").Value, err.Error())
+	}
+	return t, param, nil
+}
+
+func forElement(element *etree.Element, template string, ctx map[string]any) (string, []any, error) {
 	var slice, open, closes, column, keys string
 	var attr *etree.Attr
-	buf := bytes.Buffer{}
+	var err error
 	separator := ","
 	templateBuf := bytes.Buffer{}
 	params := make([]any, 0)
@@ -49,24 +70,24 @@ func forElement(element *etree.Element, template string, ctx map[string]any) (st
 		separator = attr.Value
 	}
 	if column != "" {
-		buf.WriteString(column + " IN ")
 		templateBuf.WriteString(column + " IN ")
 	}
 	// 上下文中取出 数据
 
-	keys = UnTemplate(slice)
+	if keys, err = unTemplate(slice); err != nil {
+		return "", nil, err
+	}
 	key := strings.Split(keys, ".")
 	// 上下文参数中找到 keys 的值 v 可能是 切片 数组，也可能是自定义的 List 数据类型等
 	v, err := ctxValue(ctx, key)
 	if err != nil {
-		return "", "", nil, err
+		return "", nil, err
 	}
 	valueOf := reflect.ValueOf(v)
 	if open != "" {
-		buf.WriteString(open)
 		templateBuf.WriteString(open)
 	}
-	var result, temp string
+	var temp string
 	var param []any
 	// 解析 slice 属性迭代
 	combine := Combine{Value: v, Template: template, Separator: separator}
@@ -76,51 +97,53 @@ func forElement(element *etree.Element, template string, ctx map[string]any) (st
 	default:
 		combine.Politic = Other{}
 	}
-	result, temp, param, err = combine.ForEach()
+	temp, param, err = combine.Render()
 	if err != nil {
-		return "", "", nil, err
+		return "", nil, err
+	}
+	// 迭代的数据本身是敏感数据时，所有元素都作为敏感参数
+	if sensitive(ctx, key) {
+		param = maskParams(param)
 	}
 	params = append(params, param...)
-	buf.WriteString(result)
 	templateBuf.WriteString(temp)
 	if closes != "" {
-		buf.WriteString(closes)
 		templateBuf.WriteString(closes)
 	}
-	return buf.String(), templateBuf.String(), params, nil
+	return templateBuf.String(), params, nil
 }
 
-func ifElement(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
+func ifElement(element *etree.Element, template string, ctx map[string]any) (string, []any, error) {
 	var attr *etree.Attr
 	attr = element.SelectAttr("expr")
 	if attr == nil {
-		return "", "", nil, fmt.Errorf("%s,attr 'expr' not found", element.Tag)
+		return "", nil, fmt.Errorf("%s,attr 'expr' not found", element.Tag)
 	}
 	exprStr := attr.Value
 	if exprStr == "" {
-		return "", "", nil, fmt.Errorf("%s,attr 'expr' value is empty", element.Tag)
+		return "", nil, fmt.Errorf("%s,attr 'expr' value is empty", element.Tag)
 	}
 	analysisExpr := AnalysisExpr(exprStr)
 	compile, err := expr.Compile(analysisExpr)
 	if err != nil {
-		return "", "", nil, err
+		return "", nil, err
 	}
 	run, err := expr.Run(compile, ctx)
 	if err != nil {
-		return "", "", nil, err
+		return "", nil, err
 	}
 	var flag, f bool
 	if flag, f = run.(bool); !f {
-		return "", "", nil, fmt.Errorf("%s,expr result is not bool type", element.Tag)
+		return "", nil, fmt.Errorf("%s,expr result is not bool type", element.Tag)
 	}
 	if flag {
-		analysisTemplate, t, param, err := AnalysisTemplate(template, ctx)
+		t, param, err := analysisTemplate(template, ctx)
 		if err != nil {
-			return "", t, param, fmt.Errorf("%s,template '%s'. %s", element.Tag, template, err.Error())
+			return t, param, fmt.Errorf("%s,template '%s'. %s", element.Tag, template, err.Error())
 		}
-		return analysisTemplate, t, param, nil
+		return t, param, nil
 	}
-	return "", "", nil, nil
+	return "", nil, nil
 }
 
 // 把 map 或者 结构体完全转化为 map[any]
@@ -158,6 +181,10 @@ func structToMap(value reflect.Value, ctx map[string]any) {
 			key = tag
 		}
 		key = strings.ToLower(key)
+		if gobatisTag(FiledType.Tag.Get("gobatis"), "sensitive") {
+			marks, _ := ctx[sensitiveMark].(sensitiveKeys)
+			ctx[sensitiveMark] = append(marks, key)
+		}
 		v := field.Interface()
 		if dataType(v) {
 			ctx[key] = v
@@ -261,12 +288,23 @@ func dataHandle(value any) (any, error) {
 	return result, nil
 }
 
-// UnTemplate 解析 {xx} 模板 解析为三个部分 ["{","xx","}"]
+// UnTemplate 解析 {xx} 模板 解析为三个部分 ["{","xx","}"]，格式错误时 panic
+//
+// Deprecated: 模板格式错误会中断程序，GoBatis 内部使用 unTemplate 返回错误
 func UnTemplate(template string) string {
-	if length := len(template); length > 3 && (template[0:1] == "{" && template[length-1:] == "}") {
-		return template[1 : length-1]
+	key, err := unTemplate(template)
+	if err != nil {
+		panic(err)
 	}
-	panic("Failed to resolve template format errors. Procedure")
+	return key
+}
+
+// unTemplate 取出 {xx} 模板中的 xx，格式错误时返回错误
+func unTemplate(template string) (string, error) {
+	if length := len(template); length > 2 && (template[0:1] == "{" && template[length-1:] == "}") {
+		return template[1 : length-1], nil
+	}
+	return "", fmt.Errorf("'%s' template format error, expected {xx}", template)
 }
 
 // AnalysisExpr 翻译表达式
@@ -296,10 +334,17 @@ func AnalysisExpr(template string) string {
 	return buf.String()
 }
 
-// AnalysisTemplate 模板解析器
+// AnalysisTemplate 模板解析器，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func AnalysisTemplate(template string, ctx map[string]any) (string, string, []any, error) {
+	return debugElement(analysisTemplate(template, ctx))
+}
+
+// analysisTemplate 模板解析器
+// 模板中的 {xx} 会被替换为 ? 占位符，对应的上下文数据按顺序作为参数返回
+func analysisTemplate(template string, ctx map[string]any) (string, []any, error) {
 	params := []any{}
-	buf := bytes.Buffer{}
 	templateBuf := bytes.Buffer{}
 	template = strings.TrimSpace(template)
 	templateByte := []byte(template)
@@ -315,75 +360,26 @@ func AnalysisTemplate(template string, ctx map[string]any) (string, string, []an
 				}
 			}
 			if starIndex == endIndex {
-				panic(fmt.Sprintf("%s Template format error\n", template[:starIndex+1]))
+				return "", params, fmt.Errorf("%s template format error, '{' is not closed", template[:starIndex+1])
 			}
 			s := template[starIndex+1 : endIndex]
 			split := strings.Split(s, ".")
 			value, err := ctxValue(ctx, split)
 			if err != nil {
-				return "", "", params, fmt.Errorf("%s,'%s' not found", template, s)
-			}
-			/*switch value.(type) {
-			case string:
-				buf.WriteString("'" + value.(string) + "'")
-				templateBuf.WriteString("?")
-				params = append(params, value)
-			case int:
-				itoa := strconv.Itoa(value.(int))
-				buf.WriteString(itoa)
-				templateBuf.WriteString("?")
-				params = append(params, value)
-			case int64:
-				itoa := strconv.Itoa(int(value.(int64)))
-				buf.WriteString(itoa)
-				templateBuf.WriteString("?")
-				params = append(params, value)
-			case float64:
-				float := strconv.FormatFloat(value.(float64), 'f', 'g', 64)
-				buf.WriteString(float)
-				templateBuf.WriteString("?")
-				params = append(params, value)
-			default:
-				// 其他复杂数据类型
-				if handle, e := dataHandle(value); e != nil {
-					return "", "", nil, e
-				} else {
-					var v string
-					switch handle.(type) {
-					case string:
-						v = "'" + handle.(string) + "'"
-					case int:
-						v = strconv.Itoa(handle.(int))
-					case float64:
-						v = strconv.FormatFloat(handle.(float64), 'f', 'f', 64)
-					case bool:
-						v = strconv.FormatBool(handle.(bool))
-					}
-					buf.WriteString(v)
-					templateBuf.WriteString("?")
-					params = append(params, handle)
-				}
-			}*/
-			//封装 数据解析
-			v, flag, err := elementValue(value)
-			if err != nil {
-				return "", "", nil, err
+				return "", params, fmt.Errorf("%s,'%s' not found", template, s)
 			}
-			if flag {
-				buf.WriteString("'" + v + "'")
-			} else {
-				buf.WriteString(v)
+			if sensitive(ctx, split) {
+				value = Sensitive{value: value}
 			}
 			templateBuf.WriteString("?")
 			params = append(params, value)
 			i = endIndex + 1
 			continue
 		}
-		buf.WriteByte(templateByte[i])
 		templateBuf.WriteByte(templateByte[i])
 		i++
 	}
-	return buf.String(), templateBuf.String(), params, nil
+	return templateBuf.String(), params, nil
 }
 
 // 上下文中取数据
@@ -442,9 +438,13 @@ func sliceCtxValue(ctx map[string]any, keys []string) (any, error) {
 	return v, nil
 }
 
-// 合并 map 吧 src 下的内容合并到 target 下，同名的 属性将被覆盖
+// 合并 map 吧 src 下的内容合并到 target 下，同名的 属性将被覆盖，敏感字段的记录会合并
 func mergeMap(target, src map[string]any) {
 	for k, v := range src {
+		if marks, b := v.(sensitiveKeys); b && k == sensitiveMark {
+			exist, _ := target[k].(sensitiveKeys)
+			v = append(append(sensitiveKeys{}, exist...), marks...)
+		}
 		target[k] = v
 	}
 }
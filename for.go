@@ -9,9 +9,15 @@ import (
 // Politic for 标签迭代实现接口扩展 标准切片之外的 List 数据支持
 type Politic interface {
 	// ForEach value 待处理迭代的数据 ctx 上下文数据 item 上下文数据key序列
+	// 返回调试 sql，带 ? 占位符的 sql 模板和参数，同时实现了 TemplatePolitic 时不再调用 ForEach
 	ForEach(value any, template string, separator string) (string, string, []any, error)
 }
 
+// TemplatePolitic for 标签迭代只生成 sql 模板和参数，不生成调试 sql，调试 sql 由 Render 按照方言渲染
+type TemplatePolitic interface {
+	Template(value any, template string, separator string) (string, []any, error)
+}
+
 type Combine struct {
 	Value     any
 	Template  string
@@ -19,16 +25,34 @@ type Combine struct {
 	Politic
 }
 
+// ForEach 返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 使用 Render
 func (c Combine) ForEach() (string, string, []any, error) {
 	return c.Politic.ForEach(c.Value, c.Template, c.Separator)
 }
 
-// AnalysisForTemplate 解析 for 标签的 文本模板
+// Render 返回带 ? 占位符的 sql 模板和参数，Politic 没有实现 TemplatePolitic 时丢弃 ForEach 返回的调试 sql
+func (c Combine) Render() (string, []any, error) {
+	if politic, b := c.Politic.(TemplatePolitic); b {
+		return politic.Template(c.Value, c.Template, c.Separator)
+	}
+	_, template, params, err := c.Politic.ForEach(c.Value, c.Template, c.Separator)
+	return template, params, err
+}
+
+// AnalysisForTemplate 解析 for 标签的 文本模板，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
+func AnalysisForTemplate(template string, ctx map[string]any, v any) (string, string, []any, error) {
+	return debugElement(analysisForTemplate(template, ctx, v))
+}
+
+// analysisForTemplate 解析 for 标签的 文本模板
 // template for标签下的文本内容
 // ctx 并不是全局的上下文数据，如果 for循环的 item是个 obj ，则ctx将表示 obj
 // v 如果 for循环的 item是个 基本类型 v 将代表它
-func AnalysisForTemplate(template string, ctx map[string]any, v any) (string, string, []any, error) {
-	buf := bytes.Buffer{}
+func analysisForTemplate(template string, ctx map[string]any, v any) (string, []any, error) {
 	templateBuf := bytes.Buffer{}
 	params := []any{}
 	template = strings.TrimSpace(template)
@@ -51,65 +75,24 @@ func AnalysisForTemplate(template string, ctx map[string]any, v any) (string, st
 			if len(split) > 1 && ctx != nil {
 				item, err = sliceCtxValue(ctx, split)
 				if err != nil {
-					return "", "", nil, fmt.Errorf("%s,'%s' not found", template, s)
+					return "", nil, fmt.Errorf("%s,'%s' not found", template, s)
+				}
+				if sensitive(ctx, split[1:]) {
+					item = Sensitive{value: item}
 				}
 			} else {
 				item = v
 			}
 			if item == nil {
-				return "", "", nil, fmt.Errorf("%s,'%s' not found", template, s)
-			}
-			/*switch item.(type) {
-			case string:
-				buf.WriteString(fmt.Sprintf(" '%s' ", item.(string)))
-				templateBuf.WriteString("?")
-				params = append(params, item)
-			case int:
-				buf.WriteString(fmt.Sprintf(" %d ", item.(int)))
-				templateBuf.WriteString("?")
-				params = append(params, item)
-			case float64:
-				buf.WriteString(fmt.Sprintf(" %f ", item.(float64)))
-				templateBuf.WriteString("?")
-				params = append(params, item)
-			default:
-				// 其他复杂数据类型
-				if handle, e := dataHandle(item); e != nil {
-					return "", "", nil, e
-				} else {
-					var v string
-					switch handle.(type) {
-					case string:
-						v = "'" + handle.(string) + "'"
-					case int:
-						v = strconv.Itoa(handle.(int))
-					case float64:
-						v = strconv.FormatFloat(handle.(float64), 'f', 'f', 64)
-					case bool:
-						v = strconv.FormatBool(handle.(bool))
-					}
-					buf.WriteString(v)
-					templateBuf.WriteString("?")
-					params = append(params, handle)
-				}
-			}*/
-			value, flag, err := elementValue(item)
-			if err != nil {
-				return "", "", nil, err
-			}
-			if flag {
-				buf.WriteString("'" + value + "'")
-			} else {
-				buf.WriteString(value)
+				return "", nil, fmt.Errorf("%s,'%s' not found", template, s)
 			}
 			templateBuf.WriteString("?")
 			params = append(params, item)
 			i = endIndex + 1
 			continue
 		}
-		buf.WriteByte(templateByte[i])
 		templateBuf.WriteByte(templateByte[i])
 		i++
 	}
-	return buf.String(), templateBuf.String(), params, nil
+	return templateBuf.String(), params, nil
 }
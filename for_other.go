@@ -3,7 +3,12 @@ package gobatis
 type Other struct {
 }
 
+// Deprecated: 使用 Template
 func (s Other) ForEach(value any, template string, separator string) (string, string, []any, error) {
+	return debugElement(s.Template(value, template, separator))
+}
+
+func (s Other) Template(value any, template string, separator string) (string, []any, error) {
 
-	return "", "", nil, nil
+	return "", nil, nil
 }
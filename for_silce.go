@@ -12,12 +12,16 @@ type Slice struct {
 	*/
 }
 
+// Deprecated: 使用 Template
 func (s Slice) ForEach(value any, template string, separator string) (string, string, []any, error) {
+	return debugElement(s.Template(value, template, separator))
+}
+
+func (s Slice) Template(value any, template string, separator string) (string, []any, error) {
 	var v any
 	var err error
-	var item, itemSql string
+	var itemSql string
 	var param []any
-	items := make([]string, 0)
 	tempSql := make([]string, 0)
 	params := make([]any, 0)
 	valueOf := reflect.ValueOf(value)
@@ -25,28 +29,25 @@ func (s Slice) ForEach(value any, template string, separator string) (string, st
 		IndexV := valueOf.Index(i)
 		v = IndexV.Interface()
 		if IndexV.Kind() == reflect.Slice {
-			return "", "", nil, fmt.Errorf("'slice' element error")
+			return "", nil, fmt.Errorf("'slice' element error")
 		}
 		if IndexV.Kind() == reflect.Map {
 			ctx := v.(map[string]any)
-			item, itemSql, param, err = AnalysisForTemplate(template, ctx, nil)
+			itemSql, param, err = analysisForTemplate(template, ctx, nil)
 			if err != nil {
-				return "", "", nil, err
+				return "", nil, err
 			}
-			items = append(items, item)
 			tempSql = append(tempSql, itemSql)
 			params = append(params, param...)
 			continue
 		}
-		item, itemSql, param, err = AnalysisForTemplate(template, nil, v)
+		itemSql, param, err = analysisForTemplate(template, nil, v)
 		if err != nil {
-			return "", "", nil, err
+			return "", nil, err
 		}
-		items = append(items, item)
 		tempSql = append(tempSql, itemSql)
 		params = append(params, param...)
 	}
-	join := strings.Join(items, separator)
 	s2 := strings.Join(tempSql, separator)
-	return join, s2, params, nil
+	return s2, params, nil
 }
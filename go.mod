@@ -5,4 +5,9 @@ go 1.19
 require (
 	github.com/antonmedv/expr v1.9.0
 	github.com/beevik/etree v1.1.0
+	github.com/go-sql-driver/mysql v1.7.0
+	github.com/iancoleman/strcase v0.2.0
+	github.com/sirupsen/logrus v1.9.0
 )
+
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
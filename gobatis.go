@@ -2,6 +2,7 @@ package gobatis
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
 	"errors"
@@ -12,11 +13,14 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 )
 
 var banner = "  ______       ______             _      \n / _____)     (____  \\       _   (_)     \n| /  ___  ___  ____)  ) ____| |_  _  ___ \n| | (___)/ _ \\|  __  ( / _  |  _)| |/___)\n| \\____/| |_| | |__)  | ( | | |__| |___ |\n \\_____/ \\___/|______/ \\_||_|\\___)_(___/ \n"
 
-func New(db *sql.DB) *GoBatis {
+// New 创建 GoBatis，db 为主库，replicas 为只读从库
+// 配置了从库时，不在事务中的 select 语句会通过 Balancer 分配到从库执行，写入语句和事务始终在主库执行
+func New(db *sql.DB, replicas ...*sql.DB) *GoBatis {
 	if db == nil {
 		Panic("db nil")
 	}
@@ -24,27 +28,105 @@ func New(db *sql.DB) *GoBatis {
 	if err != nil {
 		Panic(err)
 	}
-	return &GoBatis{
+	for _, replica := range replicas {
+		if replica == nil {
+			Panic("replica db nil")
+		}
+		if err = replica.Ping(); err != nil {
+			Panic(err)
+		}
+	}
+	batis := &GoBatis{
 		db:         reflect.ValueOf(db),
+		replicas:   replicas,
+		Balancer:   &RoundRobin{},
+		Dialect:    dialectOf(db),
 		NameSpaces: map[string]*Sql{},
-		Log:        logs,
+		Logger:     newDefaultLogger(),
+		// 默认输出全部 sql 日志
+		LogSampleRate: 1,
 	}
+	batis.Log = instanceLog{batis: batis}
+	// 默认拦截器 输出 sql 日志，统计执行指标
+	batis.metrics = newMetrics()
+	batis.interceptors = []Interceptor{logInterceptor{batis: batis}, batis.metrics}
+	return batis
 }
 
 type GoBatis struct {
+	// Log 通过 batis.Info 等方法输出的日志会转发给 Logger
+	//
+	// Deprecated: 使用 Logger，直接替换 Log 不会影响 sql 日志，切换非结构化的日志使用 Logs
 	Log
-	db reflect.Value
+	// Logger 当前实例的日志，New 创建时输出到标准输出，设置为 NopLogger 关闭日志，nil 同样不输出日志
+	Logger Logger
+	db     reflect.Value
+	// replicas 只读从库
+	replicas []*sql.DB
+	// Balancer 从库的选择策略，默认 RoundRobin 轮询，可以设置为 LeastInUse 选择使用中连接数最少的从库
+	Balancer Balancer
+	// Dialect 数据库方言，New 会根据驱动自动识别，无法识别的驱动需要手动指定
+	Dialect Dialect
+	// TxOptions mapper 函数自动开启事务和 Transaction 默认使用的事务选项，nil 表示使用数据库默认的隔离级别
+	TxOptions *sql.TxOptions
+	// AutoCommit 为 true 时，没有外部事务的单条 insert，update，delete 语句不再开启隐式事务，直接在 *sql.DB 上执行，
+	// 语句标签的 autoCommit 属性优先，批量执行始终在事务中执行
+	AutoCommit bool
+	// Timeout 语句的默认执行超时，包括结果集扫描和总数统计，重试时每次执行单独计算，语句标签的 timeout 属性优先，0 表示不设置超时
+	Timeout time.Duration
+	// Strict 为 true 时单行查询没有记录返回 ErrNoRows，多条记录返回 ErrTooManyRows，语句标签的 strict 属性优先
+	Strict bool
+	// Retry 瞬时错误的重试策略，作用于没有调用方事务的语句和 Transaction 的闭包，语句标签的 retry，retryBackoff 属性优先，零值表示不重试
+	Retry RetryPolicy
+	// SlowThreshold 执行耗时达到该值的语句以 Warn 级别输出慢查询日志，0 表示不检测慢查询
+	SlowThreshold time.Duration
+	// SlowParams 为 true 时慢查询日志输出参数值(敏感参数仍然输出 ***)，默认只输出参数的类型
+	SlowParams bool
+	// CacheFactory 为配置了 cache 标签的命名空间创建 Cache，nil 时按照 eviction 创建进程内的缓存，需要在 ScanMappers 之前设置
+	CacheFactory func(namespace string, config CacheConfig) Cache
+	// CacheCodec 缓存结果的序列化，nil 时直接缓存返回值的副本，进程外的 Cache 需要设置，例如 JSONCodec
+	CacheCodec CacheCodec
+	// CachePrefix 缓存 key 的前缀，多个 GoBatis 共用进程外的缓存并且连接不同的数据库时用于区分
+	CachePrefix string
+	// SensitiveKeys 敏感数据的 key，匹配不区分大小写，支持 path.Match 的通配符，例如 password，*token*
+	// 匹配的上下文数据和 gobatis:"sensitive" 标签的字段一样，在 sql 日志，Render 渲染的 sql 和错误信息中输出为 ***
+	SensitiveKeys []string
+	// LogSampleRate 非慢查询语句的 sql 日志采样比例，取值 0~1，New 创建时为 1 输出全部日志，0 表示只输出慢查询日志
+	LogSampleRate float64
+	// BatchSize 批量执行的分块大小，每执行多少个元素检查一次 ctx 是否被取消并输出一次执行进度，不影响事务，语句标签的 batchSize 属性优先
+	BatchSize int
+	// BatchCommitSize 批量执行分块提交的大小，由 GoBatis 开启事务时每执行多少个元素提交一次事务并开启新的事务，
+	// 0 表示所有元素在一个事务中执行，语句标签的 commitSize 属性优先
+	BatchCommitSize int
 	// SqlSource 用于保存 xml 配置的文件的根路径配置信息，Build会通过SqlSource属性去加载 xml 文件
 	SqlSource string
 	// NameSpaces 保存了每个 xml 配置的根元素构建出来的 Sql 对象
 	NameSpaces map[string]*Sql
 	// mapper 文件加载
 	mapperFS embed.FS
+	// source 当前数据源的名称，默认数据源为空字符串
+	source string
+	// root 命名数据源对应的 GoBatis 指向创建它的 GoBatis，默认数据源为 nil
+	root *GoBatis
+	// dataSources 通过 AddDataSource 注册的命名数据源
+	dataSources map[string]*dataSource
+	// dataSourceTags 保存 mapper 函数字段上通过 datasource 标签指定的数据源，key 为 namespace.函数名
+	dataSourceTags map[string]string
+	// interceptors 通过 Use 注册的拦截器，前两个为默认的日志拦截器和指标统计拦截器
+	interceptors []Interceptor
+	// metrics 默认的指标统计拦截器，通过 MetricsHandler 输出
+	metrics *metrics
+	// shards 通过 Shard 注册的分片规则
+	shards []*shardRule
+	// caches 配置了 cache 标签的命名空间的查询结果缓存，key 为 namespace
+	caches map[string]*namespaceCache
+	// txConfigs 保存 mapper 函数字段上配置的事务选项，key 为 namespace.函数名
+	txConfigs map[string]txConfig
 }
 
-// Logs 切换日志实例
+// Logs 使用非结构化的 Log 实例输出日志，日志字段以 key=value 的形式拼接在消息之后
 func (batis *GoBatis) Logs(log Log) {
-	batis.Log = log
+	batis.Logger = logAdapter{log: log}
 }
 
 // Source 加载 mapper文件
@@ -53,7 +135,10 @@ func (batis *GoBatis) Source(source string) {
 	if source != "" {
 		batis.SqlSource = source
 	}
-	fmt.Print(banner)
+	// 关闭日志时不输出 banner
+	if batis.enabled(context.Background(), LogInfo) {
+		fmt.Print(banner)
+	}
 	// 解析 xml
 	if batis.mapperFS == (embed.FS{}) && batis.SqlSource != "" {
 		getwd, err := os.Getwd()
@@ -73,7 +158,7 @@ func (batis *GoBatis) Source(source string) {
 				s := NewSql(element)
 				s.LoadSqlElement()
 				batis.NameSpaces[attr.Value] = s
-				batis.Info("load mapper file path:[" + path + "]")
+				batis.log(context.Background(), LogInfo, "load mapper file", Field{Key: "path", Value: path})
 			}
 			return nil
 		})
@@ -97,7 +182,7 @@ func (batis *GoBatis) Load(files embed.FS) {
 
 // ScanMappers 扫描解析
 func (batis *GoBatis) ScanMappers(mappers ...any) {
-	batis.Info("Start scanning the mapper mapping function")
+	batis.log(context.Background(), LogInfo, "scan mappers", Field{Key: "count", Value: len(mappers)})
 	for i := 0; i < len(mappers); i++ {
 		mapper := mappers[i]
 		vf := reflect.ValueOf(mapper)
@@ -110,7 +195,10 @@ func (batis *GoBatis) ScanMappers(mappers ...any) {
 		vf = vf.Elem()
 		namespace := vf.Type().String()
 		namespace = Namespace(namespace)
-		batis.Info("Starts loading the '" + namespace + "' mapping resolution")
+		batis.log(context.Background(), LogInfo, "load mapper", Field{Key: "namespace", Value: namespace})
+		if err := batis.initCache(namespace); err != nil {
+			Panic(namespace, ",", err.Error())
+		}
 		for j := 0; j < vf.NumField(); j++ {
 			key := make([]string, 0)
 			key = append(key, namespace)
@@ -124,64 +212,112 @@ func (batis *GoBatis) ScanMappers(mappers ...any) {
 				Panic(namespace+"."+structField.Name, ",", field.Type().String(), ",", err.Error())
 			}
 			key = append(key, structField.Name)
+			config, err := txTag(structField)
+			if err != nil {
+				Panic(namespace+"."+structField.Name, ",", err.Error())
+			}
+			if config != (txConfig{}) {
+				if batis.txConfigs == nil {
+					batis.txConfigs = map[string]txConfig{}
+				}
+				batis.txConfigs[strings.Join(key, ".")] = config
+			}
+			if name := structField.Tag.Get("datasource"); name != "" {
+				if batis.dataSourceTags == nil {
+					batis.dataSourceTags = map[string]string{}
+				}
+				batis.dataSourceTags[strings.Join(key, ".")] = name
+			}
 			batis.initMapper(key, field)
 			fun := field.Type().String()
-			index := strings.Index(fun, "(")
-			fun = " " + fun[index:]
-			batis.Info(namespace+"."+structField.Name, fun)
+			batis.log(context.Background(), LogInfo, "mapper function", Field{Key: "namespace", Value: namespace}, Field{Key: "id", Value: structField.Name}, Field{Key: "func", Value: fun[strings.Index(fun, "("):]})
 		}
 	}
 }
 
-func (batis *GoBatis) get(id []string, value any) (string, string, string, []any, error) {
-	if len(id) != 2 {
-		return "", "", "", nil, errors.New("id error")
+func (batis *GoBatis) get(id []string, value any) (string, string, []any, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return "", "", nil, err
 	}
 	ctx := toMap(value)
+	markSensitive(ctx, batis.SensitiveKeys)
+	tag, tempSql, params, err := analysis(element, ctx)
+	if err != nil {
+		return "", "", nil, err
+	}
+	temp := strings.Join(tempSql, " ")
+	return tag, temp, params, nil
+}
+
+// element 通过 [namespace, id] 找到对应的 sql 语句标签
+func (batis *GoBatis) element(id []string) (*etree.Element, error) {
+	if len(id) != 2 {
+		return nil, errors.New("id error")
+	}
 	if sql, b := batis.NameSpaces[id[0]]; b {
 		if element, f := sql.Statement[id[1]]; f {
-			analysis, tag, tempSql, params, err := Analysis(element, ctx)
-			if err != nil {
-				return "", "", "", nil, err
-			}
-			join := strings.Join(analysis, " ")
-			temp := strings.Join(tempSql, " ")
-			return join, tag, temp, params, nil
+			return element, nil
 		}
 	}
-	return "", "", "", nil, fmt.Errorf("not found sql statement element")
+	return nil, fmt.Errorf("not found sql statement element")
 }
 
-// Analysis 解析xml标签
+// Analysis 解析xml标签，返回调试 sql 片段，标签类型，带 ? 占位符的 sql 模板片段和对应的参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func Analysis(element *etree.Element, ctx map[string]any) ([]string, string, []string, []any, error) {
+	tag, template, args, err := analysis(element, ctx)
+	if err != nil {
+		return nil, "", template, args, err
+	}
+	// 按照每个片段的占位符数量切分参数，分别渲染
+	debug := make([]string, 0, len(template))
+	rest := args
+	for _, t := range template {
+		n := 0
+		for _, token := range sqlTokenIndex(t, true) {
+			if token.text == "?" {
+				n++
+			}
+		}
+		if n > len(rest) {
+			n = len(rest)
+		}
+		debug = append(debug, Render(MySQL, t, rest[:n]))
+		rest = rest[n:]
+	}
+	return debug, tag, template, args, nil
+}
+
+// analysis 解析xml标签
+// 返回标签类型，带 ? 占位符的 sql 模板片段和对应的参数
+func analysis(element *etree.Element, ctx map[string]any) (string, []string, []any, error) {
 	var err error
 	var t string
 	var params []any
 	args := make([]any, 0)
-	SQL := make([]string, 0)
 	template := make([]string, 0)
 	// 解析根标签 开始之后的文本
 	sqlStar := element.Text()
 	// 处理字符串前后空格
 	sqlStar = strings.TrimSpace(sqlStar)
 	//更具标签类型，对应解析字符串
-	sqlStar, t, params, err = Element(element, sqlStar, ctx)
+	t, params, err = elementTemplate(element, sqlStar, ctx)
 	if err != nil {
-		return nil, "", nil, nil, err
+		return "", nil, nil, err
 	}
-	SQL = append(SQL, sqlStar)
 	template = append(template, t)
 	args = append(args, params...)
 	// if 标签解析 逻辑不通过
-	if sqlStar != "" && err == nil {
+	if t != "" && err == nil {
 		// 解析子标签内容
 		child := element.ChildElements()
 		for _, childElement := range child {
-			analysis, _, tempSql, params, err := Analysis(childElement, ctx)
+			_, tempSql, params, err := analysis(childElement, ctx)
 			if err != nil {
-				return nil, "", tempSql, params, fmt.Errorf("%s -> %s error,%s", element.Tag, childElement.Tag, err.Error())
+				return "", tempSql, params, fmt.Errorf("%s -> %s error,%s", element.Tag, childElement.Tag, err.Error())
 			}
-			SQL = append(SQL, analysis...)
 			template = append(template, tempSql...)
 			args = append(args, params...)
 		}
@@ -189,32 +325,38 @@ func Analysis(element *etree.Element, ctx map[string]any) ([]string, string, []s
 	endSql := element.Tail()
 	endSql = strings.TrimSpace(endSql)
 	if endSql != "" {
-		endSql, t, params, err = Element(element.Parent(), endSql, ctx)
+		t, params, err = elementTemplate(element.Parent(), endSql, ctx)
 		if err != nil {
-			return nil, "", nil, nil, err
+			return "", nil, nil, err
 		}
-		SQL = append(SQL, endSql)
 		template = append(template, t)
 		args = append(args, params...)
 	}
-	return SQL, element.Tag, template, args, nil
+	return element.Tag, template, args, nil
 }
 
+// Element 按照标签类型解析文本，返回调试 sql，带 ? 占位符的 sql 模板和参数
+//
+// Deprecated: 调试 sql 固定按照 MySQL 方言渲染，需要调试 sql 时使用 Render 按照实际的方言渲染 sql 模板
 func Element(element *etree.Element, template string, ctx map[string]any) (string, string, []any, error) {
+	return debugElement(elementTemplate(element, template, ctx))
+}
+
+func elementTemplate(element *etree.Element, template string, ctx map[string]any) (string, []any, error) {
 	// 检擦 节点标签类型
 	tag := element.Tag
 	switch tag {
 	case For:
-		return ForElement(element, template, ctx)
+		return forElement(element, template, ctx)
 	case If:
-		return IfElement(element, template, ctx)
+		return ifElement(element, template, ctx)
 	case Select, Update, Delete, Insert:
-		return StatementElement(element, template, ctx)
+		return statementElement(element, template, ctx)
 	case Mapper:
 		// 对根标签不做任何处理
-		return "", "", nil, nil
+		return "", nil, nil
 	}
-	return "", "", nil, errors.New("error")
+	return "", nil, errors.New("error")
 }
 
 func Namespace(namespace string) string {
@@ -238,6 +380,13 @@ func MapperCheck(fun reflect.Value) (bool, error) {
 			return false, errors.New("the second return value must be error")
 		}
 	}
+	// 函数类型的参数只能是 func(T) error 形式的结果集回调
+	for i := 0; i < fun.Type().NumIn(); i++ {
+		in := fun.Type().In(i)
+		if in.Kind() == reflect.Func && !isEach(in) {
+			return false, errors.New("the callback parameter must be func(T) error")
+		}
+	}
 	return true, nil
 }
 
@@ -269,7 +418,7 @@ func (batis *GoBatis) walk(root string, list []fs.DirEntry, files embed.FS, Name
 			s := NewSql(element)
 			s.LoadSqlElement()
 			NameSpaces[attr.Value] = s
-			batis.Info("load mapper file path:[" + path + "]")
+			batis.log(context.Background(), LogInfo, "load mapper file", Field{Key: "path", Value: path})
 		}
 	}
 }
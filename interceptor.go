@@ -0,0 +1,270 @@
+package gobatis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Invocation 一次 mapper 函数调用的上下文，在拦截器之间传递
+type Invocation struct {
+	// Context mapper 函数传入的 context.Context
+	Context context.Context
+	// Namespace mapper 的命名空间
+	Namespace string
+	// Id sql 语句标签的 id
+	Id string
+	// Tag sql 语句标签 select，insert，update，delete
+	Tag string
+	// DataSource 执行语句的数据源名称，默认数据源为空字符串
+	DataSource string
+	// Dialect 执行语句的数据源方言
+	Dialect Dialect
+	// Args 渲染 sql 模板使用的上下文数据，BeforeRender 中可以修改
+	Args map[string]any
+	// Sql 渲染之后带 ? 占位符的 sql，AfterRender 和 Execute 中可以修改
+	Sql string
+	// Params Sql 对应的参数
+	Params []any
+	// Batch 批量执行时切片的元素个数，批量执行时每个元素渲染时都会调用一次 BeforeRender，AfterRender
+	Batch int
+	// Rows 查询返回的行数，或者 insert，update，delete 影响的行数
+	Rows int64
+	// Elapsed 语句执行的耗时
+	Elapsed time.Duration
+	// Results 结果映射之后 mapper 函数除 error 之外的返回值，AfterResult 中可以修改，返回迭代器时为空
+	Results []reflect.Value
+	// Err 执行的错误，AfterResult 中可以修改
+	Err error
+}
+
+// Interceptor 拦截器，通过 GoBatis.Use 注册，按照注册的顺序调用
+// 任何一个方法返回错误都会终止本次调用，错误作为 mapper 函数的 error 返回值
+type Interceptor interface {
+	// BeforeRender 渲染 sql 模板之前调用
+	BeforeRender(inv *Invocation) error
+	// AfterRender 渲染 sql 模板之后调用
+	AfterRender(inv *Invocation) error
+	// Execute 包裹语句的执行，next 执行下一个拦截器，最后一个拦截器的 next 执行语句并完成结果映射
+	Execute(inv *Invocation, next func() error) error
+	// AfterResult 执行完成之后调用，执行失败时同样会调用，返回迭代器的查询在每次迭代结束之后调用
+	AfterResult(inv *Invocation) error
+}
+
+// BaseInterceptor 所有方法都不做处理的拦截器，自定义拦截器可以嵌入它只实现需要的方法
+type BaseInterceptor struct{}
+
+func (BaseInterceptor) BeforeRender(*Invocation) error { return nil }
+
+func (BaseInterceptor) AfterRender(*Invocation) error { return nil }
+
+func (BaseInterceptor) Execute(_ *Invocation, next func() error) error { return next() }
+
+func (BaseInterceptor) AfterResult(*Invocation) error { return nil }
+
+// Use 按顺序注册拦截器，注册在前的拦截器的 Execute 在外层
+func (batis *GoBatis) Use(interceptors ...Interceptor) {
+	batis.interceptors = append(batis.interceptors, interceptors...)
+}
+
+// render 调用 BeforeRender，渲染 sql 模板，调用 AfterRender
+func (batis *GoBatis) render(inv *Invocation) error {
+	for _, interceptor := range batis.interceptors {
+		if err := interceptor.BeforeRender(inv); err != nil {
+			return err
+		}
+	}
+	_, templateSql, params, err := batis.get([]string{inv.Namespace, inv.Id}, inv.Args)
+	if err != nil {
+		return err
+	}
+	inv.Sql, inv.Params = templateSql, params
+	for _, interceptor := range batis.interceptors {
+		if err = interceptor.AfterRender(inv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute 通过拦截器链执行 run，run 的耗时记录在 inv.Elapsed，run 中的 panic 会被恢复为错误返回
+func (batis *GoBatis) execute(inv *Invocation, run func() error) error {
+	next := func() (err error) {
+		star := time.Now()
+		defer func() {
+			inv.Elapsed = time.Since(star)
+			if p := recover(); p != nil {
+				// 执行和扫描过程中的 panic 作为错误返回，由 end 回滚自动开启的事务
+				err = panicError(p)
+			}
+		}()
+		return run()
+	}
+	for i := len(batis.interceptors) - 1; i >= 0; i-- {
+		interceptor, call := batis.interceptors[i], next
+		next = func() error {
+			return interceptor.Execute(inv, call)
+		}
+	}
+	return next()
+}
+
+// end 调用 AfterResult，然后把错误写入返回值并提交或者回滚自动开启的事务
+// committed 为 true 表示 AfterResult 已经在 commit 中调用过，此时 err 为提交的错误或者 AfterResult 返回的错误
+func (batis *GoBatis) end(inv *Invocation, auto bool, results []reflect.Value, err error, BeginCall reflect.Value, committed bool) {
+	inv.Results = results[:len(results)-1]
+	if committed {
+		inv.Err = err
+	} else {
+		batis.afterResult(inv, err)
+	}
+	// 以 error 接口类型传递，零值结构体类型的错误(例如 context.DeadlineExceeded)不会被当作没有错误
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	if inv.Err != nil {
+		errType.Set(reflect.ValueOf(inv.Err))
+	}
+	End(inv.Tag, auto, results, errType, BeginCall)
+}
+
+// commit 执行成功之后调用 AfterResult 并提交自动开启的事务，在 retry 中调用，提交失败时由 retry 判断是否重新执行语句
+// AfterResult 返回错误时不提交，事务由 End 回滚；提交之后 BeginCall 被清空，End 不会再次提交
+func (batis *GoBatis) commit(inv *Invocation, results []reflect.Value, BeginCall *reflect.Value) error {
+	inv.Results = results[:len(results)-1]
+	batis.afterResult(inv, nil)
+	if inv.Err != nil {
+		return inv.Err
+	}
+	commit := BeginCall.MethodByName("Commit").Call(nil)
+	*BeginCall = reflect.Value{}
+	return errOf(commit[0])
+}
+
+// afterResult 按照注册顺序调用 AfterResult，任何一个拦截器返回错误都会替换 inv.Err 并停止调用
+func (batis *GoBatis) afterResult(inv *Invocation, err error) {
+	inv.Err = maskError(err, inv.Params)
+	for _, interceptor := range batis.interceptors {
+		if err = interceptor.AfterResult(inv); err != nil {
+			inv.Err = err
+			break
+		}
+	}
+}
+
+// errOf 把语句执行返回的错误值转换为 error
+func errOf(errType reflect.Value) error {
+	if !errType.IsValid() || errType.IsZero() {
+		return nil
+	}
+	return errType.Interface().(error)
+}
+
+// logInterceptor 默认注册的拦截器，语句执行成功之后输出 sql 日志
+// 耗时达到 GoBatis.SlowThreshold 的语句以 Warn 级别输出 slow sql 日志，执行失败的慢查询同样输出并带上 error 字段，
+// 其他执行成功的语句按照 GoBatis.LogSampleRate 采样以 Info 级别输出
+type logInterceptor struct {
+	BaseInterceptor
+	batis *GoBatis
+}
+
+func (l logInterceptor) Execute(inv *Invocation, next func() error) error {
+	err := next()
+	if l.batis.SlowThreshold > 0 && inv.Elapsed >= l.batis.SlowThreshold {
+		if l.batis.enabled(inv.Context, LogWarn) {
+			fields := append(inv.fields(),
+				Field{Key: "threshold", Value: l.batis.SlowThreshold},
+				Field{Key: "template", Value: inv.Sql},
+				Field{Key: "params", Value: redacted(inv.Params, l.batis.SlowParams)},
+				Field{Key: "caller", Value: caller()},
+			)
+			if err != nil {
+				fields = append(fields, Field{Key: "error", Value: maskError(err, inv.Params)})
+			}
+			l.batis.log(inv.Context, LogWarn, "slow sql", fields...)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if rate := l.batis.LogSampleRate; rate < 1 && (rate <= 0 || rand.Float64() >= rate) {
+		return nil
+	}
+	if !l.batis.enabled(inv.Context, LogInfo) {
+		return nil
+	}
+	switch {
+	case inv.Batch > 0:
+		l.batis.log(inv.Context, LogInfo, "sql batch", append(inv.fields(), Field{Key: "template", Value: inv.Sql}, Field{Key: "batch", Value: inv.Batch})...)
+	case inv.Tag == Select:
+		l.batis.log(inv.Context, LogInfo, "sql query", append(inv.fields(), Field{Key: "sql", Value: Render(inv.Dialect, inv.Sql, inv.Params)}, Field{Key: "template", Value: inv.Sql}, Field{Key: "params", Value: inv.Params})...)
+	default:
+		l.batis.log(inv.Context, LogInfo, "sql exec", append(inv.fields(), Field{Key: "sql", Value: Render(inv.Dialect, inv.Sql, inv.Params)}, Field{Key: "template", Value: inv.Sql}, Field{Key: "params", Value: inv.Params})...)
+	}
+	return nil
+}
+
+// fields sql 日志的公共字段
+func (inv *Invocation) fields() []Field {
+	fields := []Field{
+		{Key: "namespace", Value: inv.Namespace},
+		{Key: "id", Value: inv.Id},
+		{Key: "rows", Value: inv.Rows},
+		{Key: "duration", Value: inv.Elapsed},
+	}
+	if inv.DataSource != "" {
+		fields = append(fields, Field{Key: "datasource", Value: inv.DataSource})
+	}
+	return fields
+}
+
+// redacted 慢查询日志中输出的参数，默认只输出参数的类型，例如 <string>，敏感参数输出 ***
+// values 为 true 时输出参数值，二进制数据只输出长度，过长的字符串会被截断
+func redacted(params []any, values bool) []any {
+	list := make([]any, len(params))
+	for i, param := range params {
+		switch v := param.(type) {
+		case Sensitive:
+			list[i] = sensitiveMask
+		case nil:
+			list[i] = "<nil>"
+		case []byte:
+			list[i] = fmt.Sprintf("<%d bytes>", len(v))
+		case string:
+			if !values {
+				list[i] = "<string>"
+				continue
+			}
+			if len(v) > 64 {
+				v = v[:64] + "..."
+			}
+			list[i] = v
+		default:
+			if !values {
+				list[i] = fmt.Sprintf("<%T>", param)
+				continue
+			}
+			list[i] = param
+		}
+	}
+	return list
+}
+
+// caller 返回调用 mapper 函数的代码位置，跳过 gobatis，reflect 和 runtime 包中的栈帧
+func caller() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	pkg := reflect.TypeOf(GoBatis{}).PkgPath() + "."
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkg) && !strings.HasPrefix(frame.Function, "reflect.") && !strings.HasPrefix(frame.Function, "runtime.") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
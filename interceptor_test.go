@@ -0,0 +1,206 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hooks 通过函数字段实现拦截器，没有设置的方法不做处理
+type hooks struct {
+	BaseInterceptor
+	before, after, result func(inv *Invocation) error
+	execute               func(inv *Invocation, next func() error) error
+}
+
+func (h hooks) BeforeRender(inv *Invocation) error {
+	if h.before == nil {
+		return nil
+	}
+	return h.before(inv)
+}
+
+func (h hooks) AfterRender(inv *Invocation) error {
+	if h.after == nil {
+		return nil
+	}
+	return h.after(inv)
+}
+
+func (h hooks) Execute(inv *Invocation, next func() error) error {
+	if h.execute == nil {
+		return next()
+	}
+	return h.execute(inv, next)
+}
+
+func (h hooks) AfterResult(inv *Invocation) error {
+	if h.result == nil {
+		return nil
+	}
+	return h.result(inv)
+}
+
+// trace 记录每个方法的调用顺序
+func trace(name string, calls *[]string) hooks {
+	record := func(method string) func(*Invocation) error {
+		return func(*Invocation) error {
+			*calls = append(*calls, name+"."+method)
+			return nil
+		}
+	}
+	return hooks{
+		before: record("BeforeRender"),
+		after:  record("AfterRender"),
+		result: record("AfterResult"),
+		execute: func(inv *Invocation, next func() error) error {
+			*calls = append(*calls, name+".Execute")
+			err := next()
+			*calls = append(*calls, name+".Executed")
+			return err
+		},
+	}
+}
+
+type interceptorMapper struct {
+	Get func(ctx context.Context, arg map[string]any) ([]streamUser, error)
+	Add func(ctx context.Context, arg map[string]any) (int64, error)
+}
+
+const interceptorXml = `
+<mapper namespace="interceptorMapper">
+    <select id="Get">select id, name from user where name = {name}</select>
+    <insert id="Add">insert into user (name) values ({name})</insert>
+</mapper>`
+
+func newInterceptorMapper(t *testing.T, db *fakeDB, interceptors ...Interceptor) *interceptorMapper {
+	t.Helper()
+	batis := newFakeBatis(t, db, interceptorXml)
+	batis.Use(interceptors...)
+	mapper := &interceptorMapper{}
+	batis.ScanMappers(mapper)
+	return mapper
+}
+
+func userRows(context.Context, string, []any) (*fakeRows, error) {
+	return newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"}), nil
+}
+
+func TestInterceptorOrder(t *testing.T) {
+	var calls []string
+	db := &fakeDB{query: userRows}
+	mapper := newInterceptorMapper(t, db, trace("a", &calls), trace("b", &calls))
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	// 先注册的 Execute 在外层，其他方法按照注册顺序调用
+	want := []string{
+		"a.BeforeRender", "b.BeforeRender", "a.AfterRender", "b.AfterRender",
+		"a.Execute", "b.Execute", "b.Executed", "a.Executed",
+		"a.AfterResult", "b.AfterResult",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("%q", calls)
+	}
+}
+
+func TestInterceptorRewrite(t *testing.T) {
+	db := &fakeDB{query: userRows}
+	mapper := newInterceptorMapper(t, db, hooks{
+		before: func(inv *Invocation) error {
+			inv.Args["name"] = "b"
+			return nil
+		},
+		after: func(inv *Invocation) error {
+			inv.Sql += " and tenant = ?"
+			inv.Params = append(inv.Params, 7)
+			return nil
+		},
+	})
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if log := db.history(); !reflect.DeepEqual(log, []string{"query select id, name from user where name = ? and tenant = ? [b 7]"}) {
+		t.Errorf("%q", log)
+	}
+
+	// BeforeRender 和 AfterRender 返回错误时不会执行语句
+	denied := errors.New("denied")
+	mapper = newInterceptorMapper(t, db, hooks{after: func(*Invocation) error { return denied }})
+	if _, err := mapper.Add(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, denied) {
+		t.Error(err)
+	}
+	if log := db.history(); len(log) != 0 {
+		t.Errorf("%q", log)
+	}
+}
+
+func TestInterceptorExecute(t *testing.T) {
+	// Execute 不调用 next 时语句不会执行，返回的错误交给调用方
+	blocked := errors.New("blocked")
+	db := &fakeDB{query: userRows}
+	mapper := newInterceptorMapper(t, db, hooks{execute: func(*Invocation, func() error) error { return blocked }})
+	if users, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, blocked) || len(users) != 0 {
+		t.Fatal(users, err)
+	}
+	if log := db.history(); len(log) != 0 {
+		t.Errorf("%q", log)
+	}
+
+	// Execute 包裹执行的错误
+	broken := errors.New("broken pipe")
+	db = &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) { return nil, broken }}
+	var rows int64 = -1
+	mapper = newInterceptorMapper(t, db, hooks{execute: func(inv *Invocation, next func() error) error {
+		if err := next(); err != nil {
+			return fmt.Errorf("wrapped: %w", err)
+		}
+		rows = inv.Rows
+		return nil
+	}})
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, broken) || !strings.Contains(err.Error(), "wrapped: ") {
+		t.Fatal(err)
+	}
+	db.query = userRows
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); err != nil || rows != 2 {
+		t.Fatal(rows, err)
+	}
+}
+
+func TestInterceptorAfterResult(t *testing.T) {
+	db := &fakeDB{query: userRows}
+	var seen []streamUser
+	mapper := newInterceptorMapper(t, db, hooks{result: func(inv *Invocation) error {
+		if inv.Tag != Select {
+			return nil
+		}
+		// 结果映射已经完成，可以读取和替换返回值
+		seen = inv.Results[0].Interface().([]streamUser)
+		inv.Results[0].Set(reflect.ValueOf(seen[1:]))
+		return nil
+	}})
+	users, err := mapper.Get(context.Background(), map[string]any{"name": "a"})
+	if err != nil || len(seen) != 2 || seen[0].Name != "a" || len(users) != 1 || users[0].Name != "b" {
+		t.Fatal(seen, users, err)
+	}
+
+	// AfterResult 返回错误时替换返回的错误，自动开启的事务回滚
+	rejected := errors.New("rejected")
+	mapper = newInterceptorMapper(t, db, hooks{result: func(inv *Invocation) error {
+		if inv.Rows != 1 || inv.Results[0].Int() != 1 {
+			return fmt.Errorf("unexpected result %d", inv.Rows)
+		}
+		return rejected
+	}})
+	db.history()
+	if _, err = mapper.Add(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, rejected) {
+		t.Fatal(err)
+	}
+	if log := db.history(); !reflect.DeepEqual(log, []string{"begin", "exec insert into user (name) values (?) [a]", "rollback"}) {
+		t.Errorf("%q", log)
+	}
+}
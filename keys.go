@@ -0,0 +1,283 @@
+package gobatis
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/iancoleman/strcase"
+)
+
+// generatedKeys insert 标签 useGeneratedKeys 配置解析结果
+// 插入完成后，数据库生成的主键会按照插入顺序写回 targets 中的 property 字段
+type generatedKeys struct {
+	// property 主键对应的结构体字段名 或者 map 的 key
+	property string
+	// column 主键对应的数据库字段名，用于 RETURNING 子句
+	column string
+	// targets 需要回写主键的结构体(可寻址的结构体值)或者 map，顺序和插入的行顺序一致
+	targets []reflect.Value
+}
+
+// returningResult 通过 RETURNING 子句获取主键时，执行结果由返回的行构成
+type returningResult struct {
+	rows int64
+	id   any
+}
+
+func (r returningResult) LastInsertId() (int64, error) {
+	switch id := r.id.(type) {
+	case int64:
+		return id, nil
+	case nil:
+		return 0, fmt.Errorf("no generated key returned")
+	}
+	return strconv.ParseInt(fmt.Sprint(r.id), 10, 64)
+}
+
+func (r returningResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+// keyConfig 解析 insert 标签上的 useGeneratedKeys，keyProperty，keyColumn 属性，没有开启时返回 nil
+// Oracle 的驱动不支持 LastInsertId，RETURNING INTO 又需要输出参数，在执行语句之前直接返回错误
+func keyConfig(element *etree.Element, dialect Dialect) (*generatedKeys, error) {
+	if element.Tag != Insert {
+		return nil, nil
+	}
+	attr := element.SelectAttr("useGeneratedKeys")
+	if attr == nil || attr.Value != "true" {
+		return nil, nil
+	}
+	if dialect != nil && dialect.Name() == Oracle.Name() {
+		return nil, fmt.Errorf("%s,useGeneratedKeys is not supported by %s, the driver supports neither LastInsertId nor RETURNING INTO", element.Tag, dialect.Name())
+	}
+	keys := &generatedKeys{}
+	if attr = element.SelectAttr("keyProperty"); attr == nil || attr.Value == "" {
+		return nil, fmt.Errorf("%s,useGeneratedKeys requires attr 'keyProperty'", element.Tag)
+	}
+	keys.property = attr.Value
+	if attr = element.SelectAttr("keyColumn"); attr != nil && attr.Value != "" {
+		keys.column = attr.Value
+	}
+	return keys, nil
+}
+
+// generatedKeys 找到执行 insert 语句之后需要回写主键的参数
+// 语句中存在 <for> 标签时，回写的目标为 for 标签 slice 属性对应切片中的每个元素，否则为第一个包含 keyProperty 的参数
+// 结构体参数需要以指针的形式传入才能回写
+func (batis *GoBatis) generatedKeys(id []string, values []reflect.Value) (*generatedKeys, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := keyConfig(element, batis.Dialect)
+	if keys == nil || err != nil {
+		return nil, err
+	}
+	if forElement := element.FindElement(".//" + For); forElement != nil {
+		if attr := forElement.SelectAttr("slice"); attr != nil {
+			path := strings.Split(strings.Trim(attr.Value, "{}"), ".")
+			for _, value := range values {
+				slice := lookup(value, path)
+				if !slice.IsValid() || slice.Kind() != reflect.Slice && slice.Kind() != reflect.Array {
+					continue
+				}
+				for i := 0; i < slice.Len(); i++ {
+					keys.addTarget(slice.Index(i))
+				}
+				break
+			}
+		}
+	} else {
+		for _, value := range values {
+			if keys.addTarget(value) {
+				break
+			}
+		}
+	}
+	keys.resolveColumn()
+	return keys, nil
+}
+
+// addTarget 添加一个回写目标，value 不包含 keyProperty 时返回 false
+func (keys *generatedKeys) addTarget(value reflect.Value) bool {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return false
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		if field := value.FieldByName(keys.property); field.IsValid() && field.CanSet() {
+			keys.targets = append(keys.targets, value)
+			return true
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() == reflect.String && !value.IsNil() {
+			keys.targets = append(keys.targets, value)
+			return true
+		}
+	}
+	return false
+}
+
+// resolveColumn 没有配置 keyColumn 时，使用字段的 column 标签，或者 keyProperty 的蛇形命名
+func (keys *generatedKeys) resolveColumn() {
+	if keys.column != "" {
+		return
+	}
+	keys.column = strcase.ToSnake(keys.property)
+	if len(keys.targets) > 0 && keys.targets[0].Kind() == reflect.Struct {
+		if field, b := keys.targets[0].Type().FieldByName(keys.property); b {
+			if column := field.Tag.Get("column"); column != "" {
+				keys.column = column
+			}
+		}
+	}
+}
+
+// dest 返回第 index 个目标的主键字段地址，用于 RETURNING 结果集扫描，map 或者超出目标范围时返回 nil
+func (keys *generatedKeys) dest(index int) any {
+	if index >= len(keys.targets) || keys.targets[index].Kind() != reflect.Struct {
+		return nil
+	}
+	return keys.targets[index].FieldByName(keys.property).Addr().Interface()
+}
+
+// set 把主键写入第 index 个目标
+func (keys *generatedKeys) set(index int, id any) error {
+	if index >= len(keys.targets) {
+		return nil
+	}
+	target := keys.targets[index]
+	if target.Kind() == reflect.Map {
+		value := reflect.ValueOf(id)
+		if !value.Type().AssignableTo(target.Type().Elem()) {
+			return fmt.Errorf("generated key can not be assigned to '%s' of type %s", keys.property, target.Type().Elem().String())
+		}
+		target.SetMapIndex(reflect.ValueOf(keys.property), value)
+		return nil
+	}
+	field := target.FieldByName(keys.property)
+	if scanner, b := field.Addr().Interface().(sql.Scanner); b {
+		return scanner.Scan(id)
+	}
+	value := reflect.ValueOf(id)
+	switch {
+	case value.Type().ConvertibleTo(field.Type()) && field.Kind() != reflect.String:
+		field.Set(value.Convert(field.Type()))
+	case field.Kind() == reflect.String:
+		field.SetString(fmt.Sprint(id))
+	default:
+		return fmt.Errorf("generated key can not be assigned to '%s' of type %s", keys.property, field.Type().String())
+	}
+	return nil
+}
+
+// fill 根据 LastInsertId 推算每一行的主键并回写
+func (keys *generatedKeys) fill(dialect Dialect, exec sql.Result) error {
+	if len(keys.targets) == 0 {
+		return nil
+	}
+	lastId, err := exec.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rows, err := exec.RowsAffected()
+	if err != nil {
+		return err
+	}
+	for i, id := range dialect.InsertIds(lastId, rows) {
+		if err = keys.set(i, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyColumn 未开启 useGeneratedKeys 时返回空字符串
+func keyColumn(keys *generatedKeys) string {
+	if keys == nil {
+		return ""
+	}
+	return keys.column
+}
+
+// returning 扫描带有 RETURNING 子句的 insert 语句返回的结果集，按照返回行的顺序把主键回写到参数中
+func returning(rows *sql.Rows, keys *generatedKeys) (sql.Result, error) {
+	defer rows.Close()
+	result := returningResult{}
+	for rows.Next() {
+		var id any
+		if dest := keys.dest(int(result.rows)); dest != nil {
+			if err := rows.Scan(dest); err != nil {
+				return nil, err
+			}
+			id = reflect.ValueOf(dest).Elem().Interface()
+		} else {
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			if err := keys.set(int(result.rows), id); err != nil {
+				return nil, err
+			}
+		}
+		result.id = id
+		result.rows++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lookup 在原始参数中通过 for 标签的 slice 路径找到对应的值
+// 结构体字段按照 toMap 的规则匹配(name 标签或者字段名的小写)，map 按照 key 匹配
+func lookup(value reflect.Value, path []string) reflect.Value {
+	for _, key := range path {
+		for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return reflect.Value{}
+			}
+			value = value.Elem()
+		}
+		switch value.Kind() {
+		case reflect.Struct:
+			next := reflect.Value{}
+			for i := 0; i < value.NumField(); i++ {
+				field := value.Type().Field(i)
+				name := field.Name
+				if tag, b := field.Tag.Lookup("name"); b && tag != "" {
+					name = tag
+				}
+				if field.IsExported() && strings.ToLower(name) == key {
+					next = value.Field(i)
+					break
+				}
+			}
+			value = next
+		case reflect.Map:
+			if value.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}
+			}
+			value = value.MapIndex(reflect.ValueOf(key))
+		default:
+			return reflect.Value{}
+		}
+		if !value.IsValid() {
+			return value
+		}
+	}
+	for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
@@ -0,0 +1,209 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+type keyUser struct {
+	Id   int64  `column:"user_id"`
+	Name string `name:"username"`
+}
+
+type keyHolder struct {
+	Id   sql.NullInt64
+	Code string
+}
+
+func TestLookup(t *testing.T) {
+	users := []*keyUser{{Name: "a"}}
+	value := reflect.ValueOf(map[string]any{"args": &struct {
+		Users []*keyUser `name:"List"`
+	}{Users: users}})
+	if got := lookup(value, []string{"args", "list"}); got.Len() != 1 || got.Index(0).Interface() != users[0] {
+		t.Error(got)
+	}
+	if got := lookup(value, []string{"args", "users"}); got.IsValid() {
+		t.Error("field with name tag must be matched by tag")
+	}
+	if got := lookup(reflect.ValueOf(map[string]any{"args": nil}), []string{"args", "list"}); got.IsValid() {
+		t.Error(got)
+	}
+}
+
+func TestAddTarget(t *testing.T) {
+	keys := &generatedKeys{property: "Id"}
+	if keys.addTarget(reflect.ValueOf(keyUser{})) {
+		t.Error("struct passed by value can not be written back")
+	}
+	if keys.addTarget(reflect.ValueOf((*keyUser)(nil))) {
+		t.Error("nil pointer")
+	}
+	if keys.addTarget(reflect.ValueOf(map[int]any{})) {
+		t.Error("map with non string key")
+	}
+	if !keys.addTarget(reflect.ValueOf(&keyUser{})) || !keys.addTarget(reflect.ValueOf(map[string]any{})) {
+		t.Fatal(keys.targets)
+	}
+	keys.resolveColumn()
+	if keys.column != "user_id" {
+		t.Error(keys.column)
+	}
+}
+
+func TestSetKey(t *testing.T) {
+	user, holder, row := &keyUser{}, &keyHolder{}, map[string]any{}
+	keys := &generatedKeys{property: "Id"}
+	keys.addTarget(reflect.ValueOf(user))
+	keys.addTarget(reflect.ValueOf(holder))
+	keys.addTarget(reflect.ValueOf(row))
+	for i, id := range []any{int64(1), int64(2), int64(3)} {
+		if err := keys.set(i, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if user.Id != 1 || !holder.Id.Valid || holder.Id.Int64 != 2 || row["Id"] != int64(3) {
+		t.Error(user, holder, row)
+	}
+	if err := keys.set(3, int64(4)); err != nil {
+		t.Error("targets out of range are ignored", err)
+	}
+	if err := (&generatedKeys{property: "Id", targets: []reflect.Value{reflect.ValueOf(map[string]string{})}}).set(0, int64(1)); err == nil {
+		t.Error("expected error for map[string]string")
+	}
+
+	code := &generatedKeys{property: "Code"}
+	code.addTarget(reflect.ValueOf(holder))
+	if err := code.set(0, int64(7)); err != nil || holder.Code != "7" {
+		t.Error(holder.Code, err)
+	}
+}
+
+func TestReturning(t *testing.T) {
+	db := &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) {
+		return newRows([]string{"id"}, []driver.Value{int64(10)}, []driver.Value{int64(11)}, []driver.Value{int64(12)}), nil
+	}}
+	user, row := &keyUser{}, map[string]any{}
+	keys := &generatedKeys{property: "Id"}
+	keys.addTarget(reflect.ValueOf(user))
+	keys.addTarget(reflect.ValueOf(row))
+	rows, err := db.open().Query("insert into user (name) values (?), (?), (?) RETURNING id", "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := returning(rows, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 10 || row["Id"] != int64(11) {
+		t.Error(user, row)
+	}
+	if n, _ := result.RowsAffected(); n != 3 {
+		t.Error(n)
+	}
+	if id, _ := result.LastInsertId(); id != 12 {
+		t.Error(id)
+	}
+	if db.closed != 1 {
+		t.Error("rows not closed")
+	}
+}
+
+func TestSQLServerReturning(t *testing.T) {
+	cases := map[string]string{
+		"insert into user (name, tag) values (?, 'values')": "insert into user (name, tag) OUTPUT INSERTED.id values (?, 'values')",
+		"INSERT INTO user (name) SELECT name FROM guest":    "INSERT INTO user (name) OUTPUT INSERTED.id SELECT name FROM guest",
+		"insert into user default values":                   "insert into user OUTPUT INSERTED.id default values",
+	}
+	for sql, want := range cases {
+		if got, b := SQLServer.Returning(sql, "id"); !b || got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+	if _, b := SQLServer.Returning("exec add_user ?", "id"); b {
+		t.Error("statement without values must not be rewritten")
+	}
+
+	db := &fakeDB{driver: mssqlDriver{}, query: func(context.Context, string, []any) (*fakeRows, error) {
+		return newRows([]string{"user_id"}, []driver.Value{int64(5)}), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="keyMapper">
+    <insert id="Add" useGeneratedKeys="true" keyProperty="Id">insert into user (name) values ({username})</insert>
+</mapper>`)
+	mapper := &keyMapper{}
+	batis.ScanMappers(mapper)
+	user := &keyUser{Name: "a"}
+	if count, err := mapper.Add(context.Background(), user); err != nil || count != 1 || user.Id != 5 {
+		t.Fatal(count, user, err)
+	}
+	if log := statements(db.history(), "query"); len(log) != 1 || log[0] != "query insert into user (name) OUTPUT INSERTED.user_id values (@p1) [a]" {
+		t.Errorf("%q", log)
+	}
+}
+
+type keyMapper struct {
+	Add    func(ctx context.Context, user *keyUser) (int64, error)
+	AddAll func(ctx context.Context, users []*keyUser) (int64, error)
+}
+
+// TestPostgresReturning 驱动只接受 $n 占位符，RETURNING 语句在单条和批量执行时都要完成替换
+func TestPostgresReturning(t *testing.T) {
+	next := int64(0)
+	db := &fakeDB{driver: pgxDriver{}, query: func(_ context.Context, query string, _ []any) (*fakeRows, error) {
+		// 字符串中的 ? 不是占位符
+		if strings.Contains(strings.ReplaceAll(query, "'?'", ""), "?") {
+			return nil, errors.New(`syntax error at or near "?"`)
+		}
+		next++
+		return newRows([]string{"user_id"}, []driver.Value{next}), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="keyMapper">
+    <insert id="Add" useGeneratedKeys="true" keyProperty="Id">insert into user (name, tag) values ({username}, '?')</insert>
+    <insert id="AddAll" useGeneratedKeys="true" keyProperty="Id">insert into user (name, tag) values ({username}, '?')</insert>
+</mapper>`)
+	mapper := &keyMapper{}
+	batis.ScanMappers(mapper)
+	user := &keyUser{Name: "a"}
+	if count, err := mapper.Add(context.Background(), user); err != nil || count != 1 || user.Id != 1 {
+		t.Fatal(count, user, err)
+	}
+	users := []*keyUser{{Name: "b"}, {Name: "c"}}
+	if count, err := mapper.AddAll(context.Background(), users); err != nil || count != 2 || users[0].Id != 2 || users[1].Id != 3 {
+		t.Fatal(count, users[0], users[1], err)
+	}
+	want := []string{
+		"query insert into user (name, tag) values ($1, '?') RETURNING user_id [a]",
+		"query insert into user (name, tag) values ($1, '?') RETURNING user_id [b]",
+		"query insert into user (name, tag) values ($1, '?') RETURNING user_id [c]",
+	}
+	if log := statements(db.history(), "query"); !reflect.DeepEqual(log, want) {
+		t.Errorf("%q", log)
+	}
+}
+
+func TestKeyConfig(t *testing.T) {
+	document := etree.NewDocument()
+	if err := document.ReadFromString(`<insert id="Add" useGeneratedKeys="true" keyProperty="Id">insert into user (name) values ({name})</insert>`); err != nil {
+		t.Fatal(err)
+	}
+	element := document.Root()
+	if keys, err := keyConfig(element, SQLServer); err != nil || keys.property != "Id" {
+		t.Error(keys, err)
+	}
+	if _, err := keyConfig(element, Oracle); err == nil {
+		t.Error("oracle must reject useGeneratedKeys")
+	}
+	element.RemoveAttr("keyProperty")
+	if _, err := keyConfig(element, MySQL); err == nil {
+		t.Error("expected error without keyProperty")
+	}
+}
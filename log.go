@@ -2,15 +2,55 @@ package gobatis
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/sirupsen/logrus"
 )
 
-var logs *logrus.Logger
+// LogLevel 日志级别
+type LogLevel int
 
-// Log 自定义Log需要实现的借口
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LogDebug:
+		return "debug"
+	case LogInfo:
+		return "info"
+	case LogWarn:
+		return "warn"
+	case LogError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(level))
+}
+
+// Field 结构化日志的一个键值对
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Logger 结构化日志接口，每个 GoBatis 实例通过 Logger 字段单独配置
+// sql 日志会携带 namespace，id，datasource，duration，rows 等字段，ctx 中通过 WithRequestId 设置的请求 id 会作为 request_id 字段输出
+type Logger interface {
+	// Enabled 是否输出 level 级别的日志，返回 false 时不会构建日志字段
+	Enabled(ctx context.Context, level LogLevel) bool
+	Log(ctx context.Context, level LogLevel, msg string, fields ...Field)
+}
+
+// Log 非结构化日志接口，通过 Logs 设置之后字段会以 key=value 的形式拼接在日志消息之后
 type Log interface {
 	Info(...interface{})
 	Error(...interface{})
@@ -19,53 +59,232 @@ type Log interface {
 	Warn(...interface{})
 }
 
-func init() {
-	logs = logrus.New()
-	f := &Formatter{ProjectName: "SGO"}
-	f.Buf = &sync.Pool{New: func() any {
+// NopLogger 不输出任何日志
+type NopLogger struct{}
+
+func (NopLogger) Enabled(context.Context, LogLevel) bool { return false }
+
+func (NopLogger) Log(context.Context, LogLevel, string, ...Field) {}
+
+// logAdapter 把 Log 适配为 Logger
+type logAdapter struct {
+	log Log
+}
+
+// Enabled Log 实现了 IsLevelEnabled(logrus.Level) bool 时(例如 *logrus.Logger)按照它的级别判断，
+// 其他 Log 无法得知级别，始终返回 true，由 Log 自己过滤
+func (l logAdapter) Enabled(_ context.Context, level LogLevel) bool {
+	if leveled, ok := l.log.(interface{ IsLevelEnabled(logrus.Level) bool }); ok {
+		return leveled.IsLevelEnabled(logrusLevel(level))
+	}
+	return true
+}
+
+func (l logAdapter) Log(_ context.Context, level LogLevel, msg string, fields ...Field) {
+	msg += formatFields(fields)
+	switch level {
+	case LogDebug:
+		l.log.Debug(msg)
+	case LogWarn:
+		l.log.Warn(msg)
+	case LogError:
+		l.log.Error(msg)
+	default:
+		l.log.Info(msg)
+	}
+}
+
+// logrusLogger 通过 logrus 输出日志，字段作为 logrus 的 Fields
+type logrusLogger struct {
+	logger *logrus.Logger
+}
+
+// NewLogrusLogger 使用 logrus 实例创建 Logger
+func NewLogrusLogger(logger *logrus.Logger) Logger {
+	return logrusLogger{logger: logger}
+}
+
+// newDefaultLogger New 创建 GoBatis 时使用的日志，每个 GoBatis 拥有独立的 logrus 实例，输出到标准输出
+func newDefaultLogger() Logger {
+	return NewLogrusLogger(newLogrus())
+}
+
+func newLogrus() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&Formatter{ProjectName: "GoBatis", Buf: &sync.Pool{New: func() any {
 		return new(bytes.Buffer)
-	}}
-	logs.SetFormatter(f)
-	logs.Out = os.Stdout
+	}}})
+	logger.Out = os.Stdout
+	return logger
 }
 
-func Level(level logrus.Level) {
-	logs.SetLevel(level)
+func (l logrusLogger) Enabled(_ context.Context, level LogLevel) bool {
+	return l.logger.IsLevelEnabled(logrusLevel(level))
 }
 
+func (l logrusLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...Field) {
+	data := make(logrus.Fields, len(fields))
+	for _, field := range fields {
+		data[field.Key] = field.Value
+	}
+	entry := l.logger.WithFields(data)
+	if ctx != nil {
+		entry = entry.WithContext(ctx)
+	}
+	entry.Log(logrusLevel(level), msg)
+}
+
+func logrusLevel(level LogLevel) logrus.Level {
+	switch level {
+	case LogDebug:
+		return logrus.DebugLevel
+	case LogWarn:
+		return logrus.WarnLevel
+	case LogError:
+		return logrus.ErrorLevel
+	}
+	return logrus.InfoLevel
+}
+
+// Formatter 默认日志格式，字段按照 key 排序以 key=value 的形式输出在消息之后
 type Formatter struct {
 	ProjectName string
 	Buf         *sync.Pool
 	*logrus.TextFormatter
 }
 
+func (format *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buf := format.Buf.Get().(*bytes.Buffer)
+	defer format.Buf.Put(buf)
+	defer buf.Reset()
+	fields := make([]Field, 0, len(entry.Data))
+	for key, value := range entry.Data {
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Key < fields[j].Key
+	})
+	t := entry.Time.Format("2006-01-02 15:04:05")
+	buf.WriteString(fmt.Sprintf("[%s] %s [%s] -> %s%s\n", format.ProjectName, t, entry.Level, entry.Message, formatFields(fields)))
+	// buf 会被放回 sync.Pool，返回值需要复制
+	return append([]byte{}, buf.Bytes()...), nil
+}
+
+// formatFields 把字段格式化为 key=value 形式，包含空白的值会加上引号
+func formatFields(fields []Field) string {
+	buf := strings.Builder{}
+	for _, field := range fields {
+		value := fmt.Sprint(field.Value)
+		if strings.ContainsAny(value, " \t\r\n\"") {
+			value = fmt.Sprintf("%q", value)
+		}
+		buf.WriteString(" ")
+		buf.WriteString(field.Key)
+		buf.WriteString("=")
+		buf.WriteString(value)
+	}
+	return buf.String()
+}
+
+// requestIdKey context 中保存请求 id 的 key
+type requestIdKey struct{}
+
+// WithRequestId 在 ctx 中设置请求 id，接收该 ctx 的 mapper 函数输出的日志会携带 request_id 字段
+func WithRequestId(ctx context.Context, id string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// requestId 取出 ctx 中的请求 id
+func requestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// logs 包级别的 Info，Debug，Error 使用的日志，不会影响任何 GoBatis 实例
+var logs = newLogrus()
+
+// Level 设置包级别 Info，Debug，Error 的日志级别
+//
+// Deprecated: 日志级别不再是全局的，通过 GoBatis.Logger 配置每个实例的日志，例如 NewLogrusLogger 传入设置了级别的 logrus 实例
+func Level(level logrus.Level) {
+	logs.SetLevel(level)
+}
+
+// Info 输出一条 info 日志
+//
+// Deprecated: 使用 GoBatis.Logger
 func Info(msg ...any) {
-	logs.Infoln(msg...)
+	NewLogrusLogger(logs).Log(context.Background(), LogInfo, sprint(msg))
 }
 
+// Debug 输出一条 debug 日志
+//
+// Deprecated: 使用 GoBatis.Logger
 func Debug(msg ...any) {
-	logs.Debugln(msg...)
+	NewLogrusLogger(logs).Log(context.Background(), LogDebug, sprint(msg))
 }
 
+// Error 输出一条 error 日志
+//
+// Deprecated: 使用 GoBatis.Logger
 func Error(err ...any) {
-	logs.Errorln(err...)
+	NewLogrusLogger(logs).Log(context.Background(), LogError, sprint(err))
+}
+
+// sprint 按照 fmt.Sprintln 的规则拼接消息，去掉末尾的换行
+func sprint(v []any) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
 }
 
+// instanceLog GoBatis 内嵌的 Log 字段，把非结构化的日志转发给实例的 Logger
+type instanceLog struct {
+	batis *GoBatis
+}
+
+func (l instanceLog) Info(v ...interface{}) {
+	l.batis.log(context.Background(), LogInfo, sprint(v))
+}
+
+func (l instanceLog) Error(v ...interface{}) {
+	l.batis.log(context.Background(), LogError, sprint(v))
+}
+
+func (l instanceLog) Debug(v ...interface{}) {
+	l.batis.log(context.Background(), LogDebug, sprint(v))
+}
+
+func (l instanceLog) Warn(v ...interface{}) {
+	l.batis.log(context.Background(), LogWarn, sprint(v))
+}
+
+func (l instanceLog) Panic(v ...interface{}) {
+	l.batis.log(context.Background(), LogError, sprint(v))
+	Panic(v...)
+}
+
+// Panic 配置错误时中断程序
 func Panic(v ...any) {
-	logs.Panicln(v...)
+	panic(sprint(v))
 }
 
-func (format *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
-	buf := format.Buf.Get().(*bytes.Buffer)
-	defer format.Buf.Put(buf)
-	defer buf.Reset()
-	var f = ""
-	t := entry.Time.Format("2006-01-02 15:04:05")
-	if entry.Data != nil && len(entry.Data) > 0 {
-		f = fmt.Sprintf("[%s] %s [%s] [%s] -> %s\n", format.ProjectName, t, entry.Level, entry.Data["type"], entry.Message)
-	} else {
-		f = fmt.Sprintf("[%s] %s [%s]  -> %s\n", format.ProjectName, t, entry.Level, entry.Message)
+// enabled 当前 GoBatis 的日志是否输出 level 级别
+func (batis *GoBatis) enabled(ctx context.Context, level LogLevel) bool {
+	return batis.Logger != nil && batis.Logger.Enabled(ctx, level)
+}
+
+// log 输出一条结构化日志，ctx 中有请求 id 时追加 request_id 字段
+func (batis *GoBatis) log(ctx context.Context, level LogLevel, msg string, fields ...Field) {
+	if !batis.enabled(ctx, level) {
+		return
+	}
+	if id := requestId(ctx); id != "" {
+		fields = append(fields, Field{Key: "request_id", Value: id})
 	}
-	buf.WriteString(f)
-	return buf.Bytes(), nil
+	batis.Logger.Log(ctx, level, msg, fields...)
 }
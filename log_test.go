@@ -0,0 +1,208 @@
+//go:build go1.21
+
+package gobatis
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logEntry recordLogger 记录的一条日志
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]any
+}
+
+// recordLogger 记录所有日志，level 以下的日志不输出
+type recordLogger struct {
+	level   LogLevel
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordLogger) Enabled(_ context.Context, level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *recordLogger) Log(_ context.Context, level LogLevel, msg string, fields ...Field) {
+	data := map[string]any{}
+	for _, field := range fields {
+		data[field.Key] = field.Value
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: data})
+}
+
+// take 返回并清空记录的 msg 日志
+func (l *recordLogger) take(msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var list []logEntry
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			list = append(list, entry)
+		}
+	}
+	l.entries = nil
+	return list
+}
+
+func TestSlowLog(t *testing.T) {
+	broken := errors.New("broken pipe")
+	var fail error
+	db := &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) {
+		time.Sleep(2 * time.Millisecond)
+		if fail != nil {
+			return nil, fail
+		}
+		return newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}), nil
+	}}
+	batis := newFakeBatis(t, db, interceptorXml)
+	logger := &recordLogger{level: LogWarn}
+	batis.Logger = logger
+	batis.SlowThreshold = time.Millisecond
+	mapper := &interceptorMapper{}
+	batis.ScanMappers(mapper)
+
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	slow := logger.take("slow sql")
+	if len(slow) != 1 || slow[0].level != LogWarn || slow[0].fields["rows"] != int64(1) || slow[0].fields["template"] != "select id, name from user where name = ?" {
+		t.Fatalf("%+v", slow)
+	}
+	if _, b := slow[0].fields["error"]; b {
+		t.Error("successful statement must not have an error field")
+	}
+
+	// 执行失败的慢查询同样输出，并带上错误
+	fail = broken
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, broken) {
+		t.Fatal(err)
+	}
+	slow = logger.take("slow sql")
+	if len(slow) != 1 || fmt.Sprint(slow[0].fields["error"]) != broken.Error() {
+		t.Fatalf("%+v", slow)
+	}
+
+	// 没有达到阈值的失败语句不会输出慢查询日志
+	batis.SlowThreshold = time.Hour
+	if _, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); !errors.Is(err, broken) {
+		t.Fatal(err)
+	}
+	if slow = logger.take("slow sql"); len(slow) != 0 {
+		t.Fatalf("%+v", slow)
+	}
+}
+
+// TestInstanceLogger 每个 GoBatis 使用各自的 Logger 和级别
+func TestInstanceLogger(t *testing.T) {
+	newBatis := func(level slog.Level) (*GoBatis, *interceptorMapper, *bytes.Buffer) {
+		buf := &bytes.Buffer{}
+		batis := newFakeBatis(t, &fakeDB{query: userRows}, interceptorXml)
+		batis.Logger = NewSlogLogger(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: level})))
+		mapper := &interceptorMapper{}
+		batis.ScanMappers(mapper)
+		return batis, mapper, buf
+	}
+	_, info, infoBuf := newBatis(slog.LevelInfo)
+	warnBatis, warn, warnBuf := newBatis(slog.LevelWarn)
+	ctx := WithRequestId(context.Background(), "req-1")
+	if _, err := info.Get(ctx, map[string]any{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := warn.Get(ctx, map[string]any{"name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	out := infoBuf.String()
+	for _, want := range []string{`level=INFO msg="sql query"`, "namespace=interceptorMapper", "id=Get", "rows=2", "request_id=req-1", "template=\"select id, name from user where name = ?\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s: %s", want, out)
+		}
+	}
+	if warnBuf.Len() != 0 {
+		t.Errorf("info log written to the warn instance: %s", warnBuf.String())
+	}
+	warnBatis.Info("skipped")
+	warnBatis.Warn("kept")
+	if out = warnBuf.String(); strings.Contains(out, "skipped") || !strings.Contains(out, "level=WARN msg=kept") {
+		t.Error(out)
+	}
+	if strings.Contains(infoBuf.String(), "kept") {
+		t.Error("warn instance wrote to the info instance")
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	logger := &recordLogger{level: LogDebug}
+	batis := &GoBatis{Logger: logger}
+	batis.log(WithRequestId(context.Background(), "req-2"), LogInfo, "with")
+	batis.log(context.Background(), LogInfo, "without")
+	batis.log(nil, LogInfo, "nil")
+	if entries := logger.entries; len(entries) != 3 || entries[0].fields["request_id"] != "req-2" || len(entries[1].fields) != 0 || len(entries[2].fields) != 0 {
+		t.Errorf("%+v", entries)
+	}
+	if id := requestId(WithRequestId(nil, "req-3")); id != "req-3" {
+		t.Error(id)
+	}
+}
+
+func TestNopLogger(t *testing.T) {
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		if (NopLogger{}).Enabled(context.Background(), level) {
+			t.Error(level)
+		}
+	}
+	batis := newFakeBatis(t, &fakeDB{query: userRows}, interceptorXml)
+	batis.SlowThreshold = time.Nanosecond
+	mapper := &interceptorMapper{}
+	batis.ScanMappers(mapper)
+	if users, err := mapper.Get(context.Background(), map[string]any{"name": "a"}); err != nil || len(users) != 2 {
+		t.Fatal(users, err)
+	}
+	if batis.enabled(context.Background(), LogError) {
+		t.Error("NopLogger must disable every level")
+	}
+}
+
+// plainLog 没有级别信息的 Log
+type plainLog struct {
+	lines *[]string
+}
+
+func (l plainLog) Info(v ...interface{})  { *l.lines = append(*l.lines, sprint(v)) }
+func (l plainLog) Error(v ...interface{}) { *l.lines = append(*l.lines, sprint(v)) }
+func (l plainLog) Debug(v ...interface{}) { *l.lines = append(*l.lines, sprint(v)) }
+func (l plainLog) Panic(v ...interface{}) { *l.lines = append(*l.lines, sprint(v)) }
+func (l plainLog) Warn(v ...interface{})  { *l.lines = append(*l.lines, sprint(v)) }
+
+func TestLogAdapter(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	batis := &GoBatis{}
+	batis.Logs(logger)
+	if batis.Logger.Enabled(context.Background(), LogInfo) || !batis.Logger.Enabled(context.Background(), LogWarn) {
+		t.Error("adapter must follow the logrus level")
+	}
+	var lines []string
+	batis.Logs(plainLog{lines: &lines})
+	if !batis.Logger.Enabled(context.Background(), LogDebug) {
+		t.Error("Log without level must be enabled")
+	}
+	batis.log(context.Background(), LogDebug, "sql query", Field{Key: "id", Value: "Get"}, Field{Key: "sql", Value: "select 1"})
+	if len(lines) != 1 || lines[0] != `sql query id=Get sql="select 1"` {
+		t.Errorf("%q", lines)
+	}
+}
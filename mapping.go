@@ -4,54 +4,362 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/iancoleman/strcase"
 	"reflect"
 	"strings"
-	"time"
 )
 
 type MapperFunc func([]reflect.Value) []reflect.Value
 
 // Mapper 创建 映射函数
+// 调用过程: 选择数据源 -> 解析参数 -> 拦截器 BeforeRender -> 渲染 sql -> 拦截器 AfterRender -> 分片路由 -> 拦截器 Execute 包裹执行 -> 拦截器 AfterResult -> 提交或回滚
+// 返回的错误都会被包装为 *StatementError，调用过程中的 panic 会被恢复为错误返回
 func (batis *GoBatis) mapper(id []string, returns []reflect.Value) MapperFunc {
-	return func(values []reflect.Value) []reflect.Value {
+	return func(values []reflect.Value) (out []reflect.Value) {
 		result := createReturn(returns)
 		var errType, Exec, BeginCall reflect.Value
-		var ctx any
-		errType = reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
-		db := batis.db
-		c, ctx, db, auto := Args(db, values)
+		var inv *Invocation
+		phase := PhaseRender
+		defer func() {
+			if p := recover(); p != nil {
+				results := Return(result)
+				errOut := results[len(results)-1]
+				if !errOut.IsValid() || errOut.Type() != reflect.TypeOf(new(error)).Elem() {
+					// 最后一个返回值不是 error 无法返回错误
+					panic(p)
+				}
+				rollback(&BeginCall)()
+				errOut.Set(reflect.ValueOf(panicError(p)))
+				out = result
+			}
+			wrapStatementError(id, phase, inv, Return(result))
+		}()
+		// 按照 datasource 配置选择数据源，之后的语句都在该数据源上执行
+		batis, err := batis.bind(id)
+		if err != nil {
+			results := Return(result)
+			results[len(results)-1].Set(reflect.ValueOf(err))
+			return result
+		}
+		args := arguments(batis.db, values)
+		// 显式传入的事务优先，没有传入时加入 ctx 中当前数据源的事务
+		explicit := !args.Auto
+		batis.conn(args, explicit)
 		results := Return(result)
-		statements, tag, templateSql, params, err := batis.get(id, ctx)
+		element, err := batis.element(id)
 		if err != nil {
-			errType = reflect.ValueOf(err)
-			results[len(results)-1].Set(errType)
+			results[len(results)-1].Set(reflect.ValueOf(err))
+			return results
+		}
+		timeout, err := batis.timeout(element)
+		if err != nil {
+			results[len(results)-1].Set(reflect.ValueOf(err))
+			return results
+		}
+		policy, err := batis.retryPolicy(element)
+		if err != nil {
+			results[len(results)-1].Set(reflect.ValueOf(err))
+			return results
+		}
+		seq := len(results) > 1 && isSeq(results[0].Type())
+		c, _ := args.Ctx.Interface().(context.Context)
+		base := args.Ctx
+		var cancels []context.CancelFunc
+		defer func() {
+			for _, cancel := range cancels {
+				cancel()
+			}
+		}()
+		// deadline 超时覆盖语句执行，结果集扫描和总数统计，每次重试重新计算超时，迭代器在每次迭代时单独计算超时
+		// 自动开启的事务在 end 中提交，ctx 在 mapper 函数返回时才取消
+		deadline := func() {
+			if timeout > 0 && !seq {
+				var cancel context.CancelFunc
+				args.Ctx, cancel = withTimeout(base, timeout)
+				cancels = append(cancels, cancel)
+			}
+		}
+		deadline()
+		inv = &Invocation{Context: c, Namespace: id[0], Id: id[1], Tag: element.Tag, DataSource: batis.source, Dialect: batis.Dialect, Args: args.Args}
+		if element.Tag != Select && args.Batch.IsValid() {
+			// 切片参数 批量执行
+			if batis, err = batis.batchRoute(id, args, explicit); err != nil {
+				results[len(results)-1].Set(reflect.ValueOf(err))
+				return results
+			}
+			inv.DataSource, inv.Dialect, inv.Batch = batis.source, batis.Dialect, args.Batch.Len()
+			state := &batchState{}
+			run := func() error {
+				inv.Rows, errType = batis.batchStatement(inv, id, args.DB, args.Ctx, &BeginCall, args.Auto, args.Batch, state, results)
+				return errOf(errType)
+			}
+			phase = PhaseExecute
+			committed := false
+			if args.Auto {
+				err = batis.retry(c, policy, strings.Join(id, "."), func() error {
+					committed = false
+					if err := maskError(batis.execute(inv, run), inv.Params); err != nil || !BeginCall.IsValid() {
+						return err
+					}
+					committed = true
+					return batis.commit(inv, results, &BeginCall)
+				}, retryReset(&BeginCall, deadline))
+			} else {
+				err = batis.execute(inv, run)
+			}
+			batis.end(inv, args.Auto, results, err, BeginCall, committed)
+			// 分块提交的元素即使之后的分块失败也已经写入数据库
+			ok := errOf(results[len(results)-1]) == nil
+			locks := state.locks
+			if ok {
+				locks = append(locks, state.pending...)
+			}
+			if len(locks) > 0 {
+				next := func() {
+					for _, lock := range locks {
+						lock.next()
+					}
+				}
+				if !batis.afterCommit(c, explicit, next) {
+					next()
+				}
+			}
+			if ok || state.committed > 0 {
+				batis.flushAfterCommit(c, id[0], explicit)
+			}
+			return results
+		}
+		if err = batis.render(inv); err != nil {
+			results[len(results)-1].Set(reflect.ValueOf(err))
 			return result
 		}
+		tag := inv.Tag
+		// 分片路由 替换逻辑表名并选择分片所在的数据源
+		routes, err := batis.route(inv.Sql, inv.Args)
+		if err != nil {
+			results[len(results)-1].Set(reflect.ValueOf(err))
+			return results
+		}
+		if len(routes) > 1 {
+			if tag != Select {
+				results[len(results)-1].Set(reflect.ValueOf(fmt.Errorf("%s,%s,shard fan-out is only supported for select", tag, id[1])))
+				return results
+			}
+			phase = PhaseExecute
+			err = batis.execute(inv, func() error {
+				inv.Rows, errType = batis.fanOutStatement(id, routes, args, explicit, inv.Sql, inv.Params, results)
+				return errOf(errType)
+			})
+			batis.end(inv, false, results, err, BeginCall, false)
+			return results
+		}
+		var route *shardRoute
+		if len(routes) == 1 {
+			route = &routes[0]
+			inv.Sql = route.rewrite(inv.Sql)
+			if batis, err = batis.routed(*route); err != nil {
+				results[len(results)-1].Set(reflect.ValueOf(err))
+				return results
+			}
+			batis.conn(args, explicit)
+			inv.DataSource, inv.Dialect = batis.source, batis.Dialect
+		}
+		ctx, db, auto := args.Args, args.DB, args.Auto
+		var run func() error
+		var lock *versionLock
 		switch tag {
 		case Select:
-			errType = batis.selectStatement(db, c, statements, templateSql, params, results)
-			if errType.IsZero() {
-				// 如果 查询顺利，更具返回值个数 检查是否需要统计sql条数
-				errType = batis.selectCount(db, c, statements, results)
-
+			if auto {
+				// 不在事务中的查询 按照读写分离规则选择数据库
+				db = batis.reader(id, args.Ctx)
+			}
+			var strict bool
+			if strict, err = batis.strict(element); err != nil {
+				results[len(results)-1].Set(reflect.ValueOf(err))
+				return results
+			}
+			var countTemplate string
+			var countParams []any
+			var limit bool
+			if args.Page != nil || isPage(results[0].Type()) || len(results) == 3 && !isPresence(results) {
+				// 分页查询或者返回值需要总数时，生成总数统计语句
+				countTemplate, countParams, limit, err = batis.countQuery(id, ctx, inv.Sql, inv.Params)
+				if err != nil {
+					results[len(results)-1].Set(reflect.ValueOf(err))
+					return results
+				}
+				if route != nil {
+					countTemplate = route.rewrite(countTemplate)
+				}
+			}
+			switch {
+			case args.Each.IsValid():
+				// 回调函数 流式处理结果集
+				run = func() error {
+					inv.Rows, errType = batis.eachStatement(db, args.Ctx, inv.Sql, inv.Params, args.Each)
+					return errOf(errType)
+				}
+			case seq:
+				// 返回迭代器 延迟到迭代时执行查询
+				results[0].Set(batis.seqStatement(inv, db, args.Ctx, timeout, results[0].Type()))
+			case args.Page != nil || isPage(results[0].Type()):
+				// 分页查询 追加排序和分页之后再交给拦截器
+				var req *PageRequest
+				if req, inv.Sql, inv.Params, err = batis.pageQuery(args.Page, inv.Sql, inv.Params); err != nil {
+					results[len(results)-1].Set(reflect.ValueOf(err))
+					return results
+				}
+				run = func() error {
+					inv.Rows, errType = batis.pageStatement(db, args.Ctx, req, inv.Sql, inv.Params, countTemplate, countParams, results)
+					return errOf(errType)
+				}
+			default:
+				run = func() error {
+					if inv.Rows, errType = batis.selectStatement(db, args.Ctx, inv.Sql, inv.Params, results); errType.IsZero() {
+						// 如果 查询顺利，更具返回值个数 检查是否需要统计sql条数
+						errType = batis.selectCount(db, args.Ctx, countTemplate, countParams, limit, results)
+					}
+					if errType.IsZero() {
+						// 单行查询 检查结果集行数
+						errType = singleRow(strict, inv.Rows, results)
+					}
+					return errOf(errType)
+				}
 			}
 		case Insert, Update, Delete:
-			errType = batis.execStatement(db, c, Exec, &BeginCall, auto, statements, templateSql, params, results)
+			var keys *generatedKeys
+			if keys, err = batis.generatedKeys(id, args.Values); err != nil {
+				results[len(results)-1].Set(reflect.ValueOf(err))
+				return results
+			}
+			var opts *sql.TxOptions
+			if auto {
+				var autoCommit bool
+				if autoCommit, err = batis.autoCommit(id); err == nil && !autoCommit {
+					opts, err = batis.txOptions(id)
+				}
+				if err != nil {
+					results[len(results)-1].Set(reflect.ValueOf(err))
+					return results
+				}
+				// 自动提交模式下不开启隐式事务
+				auto = !autoCommit
+			}
+			if tag == Update {
+				// 乐观锁 参数中带有版本号字段时追加版本号条件
+				if lock, err = versionOf(args.Values); err == nil && lock != nil {
+					inv.Sql, inv.Params, err = lock.rewrite(inv.Sql, inv.Params)
+				}
+				if err != nil {
+					results[len(results)-1].Set(reflect.ValueOf(err))
+					return results
+				}
+			}
+			run = func() error {
+				inv.Rows, errType = batis.execStatement(db, args.Ctx, Exec, &BeginCall, auto, opts, inv.Sql, inv.Params, keys, results)
+				if errType.IsZero() && lock != nil && inv.Rows == 0 {
+					// 版本号不匹配 没有更新任何记录
+					return ErrOptimisticLock
+				}
+				return errOf(errType)
+			}
+		}
+		if tag == Select && run != nil && args.Auto && !args.Each.IsValid() {
+			// 二级缓存 事务之外的查询命中缓存时不再执行语句
+			if cache := batis.cacheOf(id); cache != nil {
+				key := batis.cacheKey(inv, args.Page)
+				rows, hit, err := batis.cacheGet(c, cache, key, results)
+				if err != nil {
+					batis.log(c, LogWarn, "sql cache get failed", append(inv.fields(), Field{Key: "error", Value: err})...)
+				}
+				if hit {
+					inv.Rows, run = rows, nil
+					batis.log(c, LogDebug, "sql cache hit", inv.fields()...)
+				} else {
+					query := run
+					run = func() error {
+						if err := query(); err != nil {
+							return err
+						}
+						if err := batis.cacheSet(c, cache, key, inv.Rows, results); err != nil {
+							batis.log(c, LogWarn, "sql cache set failed", append(inv.fields(), Field{Key: "error", Value: err})...)
+						}
+						return nil
+					}
+				}
+			}
+		}
+		phase = PhaseExecute
+		committed := false
+		if run != nil && args.Auto && !args.Each.IsValid() {
+			// 没有调用方事务的语句 按照重试策略重新执行，每次重试前回滚自动开启的事务
+			// 自动开启的事务在重试中提交，提交失败(例如 PostgreSQL 在 COMMIT 时返回 40001)同样会重新执行
+			err = batis.retry(c, policy, strings.Join(id, "."), func() error {
+				committed = false
+				if err := maskError(batis.execute(inv, run), inv.Params); err != nil || !BeginCall.IsValid() {
+					return err
+				}
+				committed = true
+				return batis.commit(inv, results, &BeginCall)
+			}, retryReset(&BeginCall, deadline))
+		} else if run != nil {
+			err = batis.execute(inv, run)
+		}
+		if tag == Select && seq && run == nil {
+			// 迭代器 查询在迭代时才执行，AfterResult 由 seqStatement 在迭代结束之后调用
+			return results
+		}
+		batis.end(inv, auto, results, err, BeginCall, committed)
+		if tag != Select && errOf(results[len(results)-1]) == nil {
+			if lock != nil && !batis.afterCommit(c, explicit, lock.next) {
+				// 提交成功之后回写新的版本号，在 Transaction 中执行时等到事务提交之后回写
+				lock.next()
+			}
+			batis.flushAfterCommit(c, id[0], explicit)
 		}
-		End(tag, auto, results, errType, BeginCall)
 		return results
 	}
 }
 
+// Arguments mapper 函数入参的解析结果
+type Arguments struct {
+	// Ctx 调用方传入的 context.Context，没有传入时为 context.Background()
+	Ctx reflect.Value
+	// Args 所有上下文参数合并之后的 map
+	Args map[string]any
+	// DB 执行 sql 的 *sql.DB，调用方传入事务时为对应的 *sql.Tx
+	DB reflect.Value
+	// Auto 是否由 GoBatis 自动提交事务，调用方传入事务时为 false
+	Auto bool
+	// Each 为 mapper 函数中定义的 func(T) error 回调参数，没有定义时为零值
+	Each reflect.Value
+	// Page 为 mapper 函数中传入的分页参数 PageRequest，不参与 sql 模板解析
+	Page *PageRequest
+	// Batch 为 mapper 函数中传入的切片参数，insert update delete 会对切片中的每个元素批量执行
+	Batch reflect.Value
+	// Values 合并到 Args 之前的原始上下文参数，用于执行之后回写数据(例如自增主键)
+	Values []reflect.Value
+}
+
 // Args 参数赋值处理
 // 处理定义函数的入参，返回一个参数序列给到后面的函数调用入参
+//
+// Deprecated: 只返回 ctx，上下文数据，执行的数据库和是否自动提交，回调函数，分页参数和批量执行的切片不会被识别
 func Args(db reflect.Value, values []reflect.Value) (ctx reflect.Value, args any, tx reflect.Value, auto bool) {
-	params := make(map[string]any)
-	tx = db
-	// 是否启用自动提交事务
-	auto = true
-	ctx = reflect.ValueOf(context.Background())
+	arg := arguments(db, values)
+	return arg.Ctx, arg.Args, arg.DB, arg.Auto
+}
+
+// arguments 解析 mapper 函数的入参
+func arguments(db reflect.Value, values []reflect.Value) *Arguments {
+	args := &Arguments{
+		Ctx:  reflect.ValueOf(context.Background()),
+		Args: make(map[string]any),
+		DB:   db,
+		// 是否启用自动提交事务
+		Auto: true,
+	}
 	ctxType := reflect.TypeOf(new(context.Context)).Elem()
 	txType := reflect.TypeOf(&sql.Tx{})
 	length := len(values)
@@ -59,7 +367,7 @@ func Args(db reflect.Value, values []reflect.Value) (ctx reflect.Value, args any
 		arg := values[i]
 		argType := arg.Type()
 		if argType.AssignableTo(ctxType) {
-			ctx = arg
+			args.Ctx = arg
 			continue
 		}
 		if argType.AssignableTo(txType) {
@@ -67,17 +375,38 @@ func Args(db reflect.Value, values []reflect.Value) (ctx reflect.Value, args any
 				// 针对 txType 为空，我们不选择采用 外部提供的事务，主要方便支持一个定义灵活调用情况
 				continue
 			}
-			tx = arg
+			args.DB = arg
 			// 外部提供 事务，GoBatis 内部不自动提交
-			auto = false
+			args.Auto = false
 			continue
 		}
-		args = arg.Interface()
-		m := toMap(args)
-		mergeMap(params, m)
+		if argType == reflect.TypeOf(PageRequest{}) {
+			req := arg.Interface().(PageRequest)
+			args.Page = &req
+			continue
+		}
+		if argType == reflect.TypeOf(&PageRequest{}) {
+			if !arg.IsNil() {
+				req := *arg.Interface().(*PageRequest)
+				args.Page = &req
+			}
+			continue
+		}
+		if argType.Kind() == reflect.Func {
+			if !arg.IsNil() {
+				args.Each = arg
+			}
+			continue
+		}
+		if argType.Kind() == reflect.Slice && argType.Elem().Kind() != reflect.Uint8 {
+			args.Batch = arg
+			continue
+		}
+		args.Values = append(args.Values, arg)
+		m := toMap(arg.Interface())
+		mergeMap(args.Args, m)
 	}
-	args = params
-	return
+	return args
 }
 
 // Return 处理返回值排序
@@ -99,120 +428,138 @@ func Return(result []reflect.Value) (ret []reflect.Value) {
 }
 
 // SelectStatement 执行查询
-func (batis *GoBatis) selectStatement(db, ctx reflect.Value, statements, templateSql string, params []any, result []reflect.Value) reflect.Value {
+func (batis *GoBatis) selectStatement(db, ctx reflect.Value, templateSql string, params []any, result []reflect.Value) (int64, reflect.Value) {
 	var resultType reflect.Value
-	star := time.Now()
 	Query := db.MethodByName("QueryContext")
 	call := Query.CallSlice([]reflect.Value{
 		ctx,
-		reflect.ValueOf(templateSql),
-		reflect.ValueOf(params),
+		reflect.ValueOf(batis.Dialect.Rebind(templateSql)),
+		reflect.ValueOf(rawParams(params)),
 	})
 	if !call[1].IsZero() {
-		return call[1]
+		return 0, call[1]
 	}
+	defer call[0].MethodByName("Close").Call(nil)
 	if result[0].Kind() == reflect.Slice {
-		// 拿到 切片元素类型
-		sliceType := result[0].Type().Elem()
-		// 切片是没有具体元素，所以需要创建一个具体的元素
-		switch sliceType.Kind() {
-		case reflect.Pointer:
-			// 创建 指针
-			resultType = reflect.New(sliceType).Elem()
-			// 创建 指针 *
-			elem := reflect.New(sliceType.Elem())
-			// 给指针 设置一个对应的指针值
-			resultType.Set(elem)
-		//case reflect.Struct:
-		//	resultType = reflect.New(sliceType).Elem()
-		//case reflect.String:
-		//	resultType = reflect.New(sliceType).Elem()
-		default:
-			resultType = reflect.New(sliceType).Elem()
-		}
+		// 拿到 切片元素类型, 切片是没有具体元素，所以需要创建一个具体的元素
+		resultType = resultElem(result[0].Type().Elem())
 	} else {
 		resultType = result[0]
 	}
 	value, err := resultMapping(call[0], resultType.Interface())
 	if !err.IsZero() {
-		return err
+		return 0, err
 	}
 	QueryResultMapper(value, result)
-	end := time.Now()
-	batis.Info("\r\nSQL Query Statements ==> ", statements, "\r\nSQL Template ==> ", templateSql, "\r\nParameter: ", params, " Count: (", value.Len(), "), Time: ", end.Sub(star).String())
-	return err
+	return int64(value.Len()), err
+}
+
+// resultElem 根据结果集元素类型创建一个用于接收单行数据的值
+func resultElem(elemType reflect.Type) reflect.Value {
+	value := reflect.New(elemType).Elem()
+	if elemType.Kind() == reflect.Pointer {
+		// 给指针 设置一个对应的指针值
+		value.Set(reflect.New(elemType.Elem()))
+	}
+	return value
 }
 
 // selectCount 统计 sql 数量
-func (batis *GoBatis) selectCount(db, ctx reflect.Value, statements string, result []reflect.Value) reflect.Value {
-	var countSql string
-	var flag bool
+// 返回值为 ([]T, int64, error) 形式时，把查询语句的总数写入 int64 返回值
+// limit 为 false 表示查询语句没有分页，结果集的长度就是总数，不再执行统计语句
+func (batis *GoBatis) selectCount(db, ctx reflect.Value, countSql string, params []any, limit bool, result []reflect.Value) reflect.Value {
 	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
-	if len(result) != 3 {
-		return errType
-	}
-	if countSql, flag = createCountSql(statements); !flag {
+	if len(result) != 3 || result[0].Kind() != reflect.Slice {
 		return errType
 	}
-	Query := db.MethodByName("QueryContext")
-	call := Query.Call([]reflect.Value{
-		ctx,
-		reflect.ValueOf(countSql),
-	})
-	if !call[1].IsZero() {
-		return call[1]
-	}
-	row := call[0]
-	scan := row.MethodByName("Scan")
-	next := row.MethodByName("Next")
-	count := reflect.New(reflect.TypeOf(int64(0)))
-	for (next.Call(nil))[0].Interface().(bool) {
-		scanErr := scan.Call([]reflect.Value{count})
-		if !scanErr[0].IsZero() {
-			// 扫描错误返回给调用者
-			return scanErr[0]
+	total := int64(result[0].Len())
+	if limit {
+		var err error
+		if total, err = batis.count(db, ctx, countSql, params); err != nil {
+			return reflect.ValueOf(err)
 		}
 	}
-	for i := 0; i < len(result); i++ {
-		if count.Elem().Type().AssignableTo(result[i].Type()) {
-			result[i].Set(count.Elem())
+	for i := 1; i < len(result)-1; i++ {
+		if result[i].Kind() == reflect.Int64 {
+			result[i].SetInt(total)
 		}
 	}
 	return errType
 }
 
 // ExecStatement 执行修改
-func (batis *GoBatis) execStatement(db, ctx, Exec reflect.Value, BeginCall *reflect.Value, auto bool, statements, templateSql string, params []any, result []reflect.Value) reflect.Value {
-	star := time.Now()
+// auto 为 true 时使用 opts 开启事务，keys 不为 nil 时，执行完成后把数据库生成的主键回写到参数中
+func (batis *GoBatis) execStatement(db, ctx, Exec reflect.Value, BeginCall *reflect.Value, auto bool, opts *sql.TxOptions, templateSql string, params []any, keys *generatedKeys, result []reflect.Value) (int64, reflect.Value) {
 	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
 	if !auto {
 		Exec = db.MethodByName("ExecContext")
 	} else {
-		BeginFunc := db.MethodByName("Begin")
-		call := BeginFunc.Call(nil)
+		BeginFunc := db.MethodByName("BeginTx")
+		call := BeginFunc.Call([]reflect.Value{ctx, reflect.ValueOf(opts)})
 		if !call[1].IsZero() {
-			return call[1]
+			return 0, call[1]
 		}
 		*BeginCall = call[0]
+		db = *BeginCall
 		Exec = BeginCall.MethodByName("ExecContext")
 	}
-	call := Exec.CallSlice([]reflect.Value{
-		ctx,
-		reflect.ValueOf(templateSql),
-		reflect.ValueOf(params),
-	})
-	if !call[1].IsZero() {
-		return call[1]
+	var exec sql.Result
+	if returningSql, b := batis.Dialect.Returning(templateSql, keyColumn(keys)); keys != nil && b {
+		// 通过 RETURNING 子句获取主键
+		Query := db.MethodByName("QueryContext")
+		call := Query.CallSlice([]reflect.Value{
+			ctx,
+			reflect.ValueOf(batis.Dialect.Rebind(returningSql)),
+			reflect.ValueOf(rawParams(params)),
+		})
+		if !call[1].IsZero() {
+			return 0, call[1]
+		}
+		var err error
+		if exec, err = returning(call[0].Interface().(*sql.Rows), keys); err != nil {
+			return 0, reflect.ValueOf(err)
+		}
+	} else {
+		call := Exec.CallSlice([]reflect.Value{
+			ctx,
+			reflect.ValueOf(batis.Dialect.Rebind(templateSql)),
+			reflect.ValueOf(rawParams(params)),
+		})
+		if !call[1].IsZero() {
+			return 0, call[1]
+		}
+		exec = call[0].Interface().(sql.Result)
+		if keys != nil {
+			if err := keys.fill(batis.Dialect, exec); err != nil {
+				return 0, reflect.ValueOf(err)
+			}
+		}
 	}
 	var count int64
-	count, err := ExecResultMapper(result, call[0].Interface().(sql.Result))
+	count, err := ExecResultMapper(result, exec)
 	if err != nil {
 		errType.Set(reflect.ValueOf(err))
-		return errType
+		return 0, errType
+	}
+	return count, errType
+}
+
+// rollback 返回重试之前回滚自动开启的事务的函数，回滚之后清空 BeginCall，下一次执行重新开启事务
+func rollback(BeginCall *reflect.Value) func() {
+	return func() {
+		if BeginCall.IsValid() {
+			BeginCall.MethodByName("Rollback").Call(nil)
+			*BeginCall = reflect.Value{}
+		}
+	}
+}
+
+// retryReset 重试之前回滚自动开启的事务，并为下一次执行重新计算超时
+func retryReset(BeginCall *reflect.Value, deadline func()) func() {
+	return func() {
+		rollback(BeginCall)()
+		deadline()
 	}
-	end := time.Now()
-	batis.Info("\r\nSQL Exec Statements ==> ", statements, "\r\nSQL Template ==> ", templateSql, "\r\nParameter: ", params, ", Count: (", count, "), Time: ", end.Sub(star).String())
-	return errType
 }
 
 // End 错误提交及回滚
@@ -221,10 +568,11 @@ func End(tag string, auto bool, result []reflect.Value, errType, BeginCall refle
 	outEnd := (result)[length-1]
 	if errType.Type().AssignableTo(outEnd.Type()) && !errType.IsZero() {
 		outEnd.Set(errType)
-		if auto && tag != Select {
+		if auto && tag != Select && BeginCall.IsValid() {
 			RollbackFunc := BeginCall.MethodByName("Rollback")
 			Rollback := RollbackFunc.Call(nil)
-			if !Rollback[0].IsZero() {
+			// ctx 超时或者取消时 database/sql 已经回滚了事务，保留原来的错误
+			if !Rollback[0].IsZero() && !errors.Is(Rollback[0].Interface().(error), sql.ErrTxDone) {
 				outEnd.Set(Rollback[0])
 			}
 		}
@@ -296,68 +644,120 @@ func initField(value reflect.Value) {
 }
 
 func resultMapping(row reflect.Value, resultType any) (reflect.Value, reflect.Value) {
+	t := reflect.SliceOf(reflect.TypeOf(resultType))
+	result := reflect.MakeSlice(t, 0, 0)
+	scanner, err := newRowScanner(row, resultType)
+	if err != nil {
+		return result, reflect.ValueOf(err)
+	}
+	for scanner.Next() {
+		value, err := scanner.Scan()
+		if err != nil {
+			// 扫描错误返回给调用者
+			return reflect.Value{}, reflect.ValueOf(err)
+		}
+		// 添加结果集
+		result = reflect.Append(result, value)
+	}
+	if err = scanner.Err(); err != nil {
+		return reflect.Value{}, reflect.ValueOf(err)
+	}
+	return result, reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+}
+
+// rowScanner 结果集逐行扫描器，每次只创建并填充一行记录对应的接收变量
+type rowScanner struct {
+	resultType any
+	column     []string
+	mapping    map[string]string
+	scan, next reflect.Value
+	rowErr     reflect.Value
+}
+
+func newRowScanner(row reflect.Value, resultType any) (*rowScanner, error) {
 	var err error
 	var flag bool
 	var column []string
-	t := reflect.SliceOf(reflect.TypeOf(resultType))
-	result := reflect.MakeSlice(t, 0, 0)
 	// 确定数据库 列顺序 排列扫描顺序
 	columns := row.MethodByName("Columns").Call(nil)
 	if !columns[1].IsZero() {
-		return result, columns[1]
+		return nil, &scanError{err: columns[1].Interface().(error)}
 	}
 	if column, flag = columns[0].Interface().([]string); !flag {
-		return result, reflect.ValueOf(errors.New("get row column error"))
+		return nil, &scanError{err: errors.New("get row column error")}
 	}
 	// 校验 resultType 是否覆盖了结果集
 	if flag, err = SelectCheck(column, resultType); !flag {
-		return result, reflect.ValueOf(err)
+		return nil, &scanError{err: err}
+	}
+	return &rowScanner{
+		resultType: resultType,
+		column:     column,
+		// 解析结构体 映射字段
+		mapping: ResultMapping(resultType),
+		// 拿到 scan 方法
+		scan:   row.MethodByName("Scan"),
+		next:   row.MethodByName("Next"),
+		rowErr: row.MethodByName("Err"),
+	}, nil
+}
+
+// Next 移动到下一行记录
+func (s *rowScanner) Next() bool {
+	return (s.next.Call(nil))[0].Interface().(bool)
+}
+
+// Err 返回迭代结果集期间产生的错误
+func (s *rowScanner) Err() error {
+	if call := s.rowErr.Call(nil); !call[0].IsZero() {
+		return call[0].Interface().(error)
 	}
-	// 解析结构体 映射字段
-	// 拿到 scan 方法
-	scan := row.MethodByName("Scan")
-	next := row.MethodByName("Next")
-	mapping := ResultMapping(resultType)
-	for (next.Call(nil))[0].Interface().(bool) {
-		var value, unValue reflect.Value
-		if reflect.TypeOf(resultType).Kind() == reflect.Pointer {
-			//创建一个 接收结果集的变量
-			value = reflect.New(reflect.TypeOf(resultType).Elem())
-			unValue = value.Elem()
-			// 初始化 内部指针
-			initField(unValue)
-		} else if reflect.TypeOf(resultType).Kind() == reflect.Map {
-			value = reflect.MakeMap(reflect.TypeOf(resultType))
-			unValue = value
-		} else {
-			value = reflect.New(reflect.TypeOf(resultType))
-			value = value.Elem()
-			unValue = value
-			// 初始化 内部指针
-			initField(unValue)
-		}
-		// 创建 接收器
-		values, fieldIndexMap, MapKey := buildScan(unValue, column, mapping)
-		// 执行扫描, 执行结果扫描
-		scanErr := scan.Call(values)
-		if !scanErr[0].IsZero() {
-			// 扫描错误返回给调用者
-			return reflect.Value{}, scanErr[0]
-		}
-		// 迭代是否有特殊结构体 主要对 时间类型做了处理
-		scanWrite(values, fieldIndexMap)
-		scanMap(unValue, values, MapKey)
-		// 添加结果集
-		result = reflect.Append(result, value)
+	return nil
+}
+
+// Scan 扫描当前行记录，返回一个 resultType 类型的值
+func (s *rowScanner) Scan() (reflect.Value, error) {
+	var value, unValue reflect.Value
+	resultType := reflect.TypeOf(s.resultType)
+	if resultType.Kind() == reflect.Pointer {
+		//创建一个 接收结果集的变量
+		value = reflect.New(resultType.Elem())
+		unValue = value.Elem()
+		// 初始化 内部指针
+		initField(unValue)
+	} else if resultType.Kind() == reflect.Map {
+		value = reflect.MakeMap(resultType)
+		unValue = value
+	} else {
+		value = reflect.New(resultType)
+		value = value.Elem()
+		unValue = value
+		// 初始化 内部指针
+		initField(unValue)
 	}
-	return result, reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	// 创建 接收器
+	values, fieldIndexMap, MapKey, err := buildScan(unValue, s.column, s.mapping)
+	if err != nil {
+		return reflect.Value{}, &scanError{err: err}
+	}
+	// 执行扫描, 执行结果扫描
+	scanErr := s.scan.Call(values)
+	if !scanErr[0].IsZero() {
+		return reflect.Value{}, &scanError{err: scanErr[0].Interface().(error)}
+	}
+	// 迭代是否有特殊结构体 主要对 时间类型做了处理
+	if err = scanWrite(values, fieldIndexMap); err != nil {
+		return reflect.Value{}, &scanError{err: err}
+	}
+	scanMap(unValue, values, MapKey)
+	return value, nil
 }
 
 // 构建结构体接收器
 // value 接收数据库结果对应的参数，可能是结构体也可能是 map
 // columns 数据库结果集的列名
 // resultColumn 对应 value(结构体类型)参数 和 columns 参数的 映射关系，value(map类型)时候 该值为空
-func buildScan(value reflect.Value, columns []string, resultColumn map[string]string) ([]reflect.Value, map[int]reflect.Value, map[int]string) {
+func buildScan(value reflect.Value, columns []string, resultColumn map[string]string) ([]reflect.Value, map[int]reflect.Value, map[int]string, error) {
 	// Scan 函数调用参数列表,接收器存储的都是指针类 反射的指针类型
 	values := make([]reflect.Value, 0)
 	// 存储的 也将是指针的反射形式
@@ -365,7 +765,7 @@ func buildScan(value reflect.Value, columns []string, resultColumn map[string]st
 	MapKey := make(map[int]string)
 	if len(columns) == 1 {
 		values = append(values, value.Addr())
-		return values, fieldIndexMap, MapKey
+		return values, fieldIndexMap, MapKey, nil
 	}
 	// 创建 接收器
 	for index, column := range columns {
@@ -380,7 +780,7 @@ func buildScan(value reflect.Value, columns []string, resultColumn map[string]st
 		Field := value.FieldByName(name)
 		if Field == (reflect.Value{}) {
 			// 没有找到对应的
-			panic("The '" + column + "' of the result set does not match the structure '" + value.Type().String() + "',the type of the returned value does not match the result set of the sql query, and the mapping fails. Check whether the structure field name or 'column' tag matches the mapping relationship of the query data set")
+			return nil, nil, nil, errors.New("The '" + column + "' of the result set does not match the structure '" + value.Type().String() + "',the type of the returned value does not match the result set of the sql query, and the mapping fails. Check whether the structure field name or 'column' tag matches the mapping relationship of the query data set")
 		}
 		// 检查 接收参数 如果是特殊参数 比如结构体，时间类型的情况需要特殊处理 当前仅对时间进行特殊处理 ,获取当前 参数的 values 索引 并保存替换
 		// fieldIndexMap 存储的是对应字段的地址，若字段类型为指针，则要为指针分配地址后进行保存
@@ -410,12 +810,12 @@ func buildScan(value reflect.Value, columns []string, resultColumn map[string]st
 		}
 		values = append(values, Field.Addr())
 	}
-	return values, fieldIndexMap, MapKey
+	return values, fieldIndexMap, MapKey, nil
 }
 
 // 对 buildScan 函数构建阶段存在特殊字段的处理 进行回写到指定的结构体位置
 // values 数据结果集 一行记录
-func scanWrite(values []reflect.Value, fieldIndexMap map[int]reflect.Value) {
+func scanWrite(values []reflect.Value, fieldIndexMap map[int]reflect.Value) error {
 	var fun ToGolang
 	var b bool
 	// 迭代是否有特殊结构体 主要对 时间类型做了处理
@@ -424,18 +824,18 @@ func scanWrite(values []reflect.Value, fieldIndexMap map[int]reflect.Value) {
 		mapV := values[k]
 		key := BaseTypeKey(v)
 		if fun, b = databaseToGolang[key]; !b {
-			// 进行自定义 数据映射期间找不到对应的匹配处理器，将产生恐慌提示用户对这个数据类型应该提供一个处理注册
+			// 进行自定义 数据映射期间找不到对应的匹配处理器，返回错误提示用户对这个数据类型应该提供一个处理注册
 			// 没有找到对应的数据处理，可以通过 gobatis.GolangType 方法对 具体类型进行注册
-			Panic("The data processor corresponding to the '" + key + "' is not occupied. You need to register GolangType to support this type")
+			return errors.New("The data processor corresponding to the '" + key + "' is not occupied. You need to register GolangType to support this type")
 		}
 		if fun == nil {
 			continue
 		}
-		err := fun(v, mapV.Elem().Interface())
-		if err != nil {
-			Panic(err)
+		if err := fun(v, mapV.Elem().Interface()); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 // scanMap select 查询返回的结果集是 map 的处理方式
@@ -532,12 +932,6 @@ func QueryResultMapper(value reflect.Value, result []reflect.Value) {
 // insert,update,delete,默认第一个返回值为 执行sql 影响的具体行数
 // insert 第二个返回参数是 自增长主键
 func ExecResultMapper(result []reflect.Value, exec sql.Result) (count int64, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			err = errors.New(e.(string))
-			count = -1
-		}
-	}()
 	var lid int64
 	length := len(result)
 	if length-1 == 0 {
@@ -552,14 +946,18 @@ func ExecResultMapper(result []reflect.Value, exec sql.Result) (count int64, err
 			if err != nil {
 				return
 			}
-			result[i].Set(reflect.ValueOf(count))
+			if err = setInt(result[i], count); err != nil {
+				return -1, err
+			}
 		}
 		if i == 1 {
 			lid, err = exec.LastInsertId()
 			if err != nil {
 				return
 			}
-			result[i].Set(reflect.ValueOf(lid))
+			if err = setInt(result[i], lid); err != nil {
+				return -1, err
+			}
 		}
 		if i > 1 {
 			break
@@ -568,6 +966,19 @@ func ExecResultMapper(result []reflect.Value, exec sql.Result) (count int64, err
 	return
 }
 
+// setInt 把影响行数或者自增主键写入整数类型的返回值
+func setInt(out reflect.Value, value int64) error {
+	switch {
+	case out.CanInt():
+		out.SetInt(value)
+	case out.CanUint():
+		out.SetUint(uint64(value))
+	default:
+		return fmt.Errorf("the return value type %s can not receive %d, insert, update, delete must return integers", out.Type().String(), value)
+	}
+	return nil
+}
+
 func createReturn(returns []reflect.Value) []reflect.Value {
 	values := make([]reflect.Value, len(returns))
 	for index, value := range returns {
@@ -586,17 +997,3 @@ func createReturn(returns []reflect.Value) []reflect.Value {
 	}
 	return values
 }
-
-// createCountSql 更具 statements 和 templateSql 生产 count(*) sql
-func createCountSql(statements string) (string, bool) {
-	var star, end, limit int
-	lower := strings.ToLower(statements)
-	star = strings.Index(lower, "select")
-	end = strings.Index(lower, "from")
-	limit = strings.LastIndex(lower, "limit")
-	if limit < 0 {
-		return "", false
-	}
-	countSql := statements[star:6] + " count(*) " + statements[end:limit]
-	return countSql, true
-}
@@ -0,0 +1,197 @@
+package gobatis
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsBuckets 执行耗时直方图的桶(秒)，和 Prometheus 客户端的默认桶一致
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricsKey 统计指标的标签
+type metricsKey struct {
+	namespace string
+	id        string
+	outcome   string
+}
+
+// statementStats 一组标签下的统计数据
+type statementStats struct {
+	count   uint64
+	rows    int64
+	sum     float64
+	buckets []uint64
+}
+
+// metrics 默认注册的拦截器，按照 namespace，语句 id，执行结果统计执行次数，耗时分布和行数
+type metrics struct {
+	BaseInterceptor
+	mu    sync.Mutex
+	stats map[metricsKey]*statementStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{stats: map[metricsKey]*statementStats{}}
+}
+
+func (m *metrics) Execute(inv *Invocation, next func() error) error {
+	err := next()
+	key := metricsKey{namespace: inv.Namespace, id: inv.Id, outcome: "success"}
+	if err != nil {
+		key.outcome = "error"
+	}
+	seconds := inv.Elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, b := m.stats[key]
+	if !b {
+		stats = &statementStats{buckets: make([]uint64, len(metricsBuckets))}
+		m.stats[key] = stats
+	}
+	stats.count++
+	stats.rows += inv.Rows
+	stats.sum += seconds
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			stats.buckets[i]++
+		}
+	}
+	return err
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出统计指标的 http.Handler
+// 包括每条语句的执行次数，耗时直方图，返回或者影响的行数，以及每个数据源(包括从库)的 sql.DBStats
+func (batis *GoBatis) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		batis.writeMetrics(w)
+	})
+}
+
+// writeMetrics 输出 Prometheus 文本格式的统计指标
+func (batis *GoBatis) writeMetrics(w io.Writer) {
+	if batis.metrics != nil {
+		batis.metrics.write(w)
+	}
+	writeDBStats(w, batis.dbStats())
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	keys := make([]metricsKey, 0, len(m.stats))
+	stats := make(map[metricsKey]statementStats, len(m.stats))
+	for key, s := range m.stats {
+		keys = append(keys, key)
+		stats[key] = statementStats{count: s.count, rows: s.rows, sum: s.sum, buckets: append([]uint64{}, s.buckets...)}
+	}
+	m.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.namespace != b.namespace {
+			return a.namespace < b.namespace
+		}
+		if a.id != b.id {
+			return a.id < b.id
+		}
+		return a.outcome < b.outcome
+	})
+	fmt.Fprintln(w, "# HELP gobatis_statements_total Number of executed mapper statements.")
+	fmt.Fprintln(w, "# TYPE gobatis_statements_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "gobatis_statements_total{%s} %d\n", key.labels(), stats[key].count)
+	}
+	fmt.Fprintln(w, "# HELP gobatis_statement_rows_total Number of rows returned or affected by mapper statements.")
+	fmt.Fprintln(w, "# TYPE gobatis_statement_rows_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "gobatis_statement_rows_total{%s} %d\n", key.labels(), stats[key].rows)
+	}
+	fmt.Fprintln(w, "# HELP gobatis_statement_duration_seconds Execution time of mapper statements.")
+	fmt.Fprintln(w, "# TYPE gobatis_statement_duration_seconds histogram")
+	for _, key := range keys {
+		s := stats[key]
+		for i, bound := range metricsBuckets {
+			fmt.Fprintf(w, "gobatis_statement_duration_seconds_bucket{%s,le=\"%s\"} %d\n", key.labels(), formatFloat(bound), s.buckets[i])
+		}
+		fmt.Fprintf(w, "gobatis_statement_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), s.count)
+		fmt.Fprintf(w, "gobatis_statement_duration_seconds_sum{%s} %s\n", key.labels(), formatFloat(s.sum))
+		fmt.Fprintf(w, "gobatis_statement_duration_seconds_count{%s} %d\n", key.labels(), s.count)
+	}
+}
+
+func (key metricsKey) labels() string {
+	return fmt.Sprintf("namespace=\"%s\",id=\"%s\",outcome=\"%s\"", labelValue(key.namespace), labelValue(key.id), key.outcome)
+}
+
+// dbInstance 一个数据库连接池，datasource 为数据源名称，默认数据源为 default，role 为 primary 或者 replica-N
+type dbInstance struct {
+	datasource string
+	role       string
+	stats      sql.DBStats
+}
+
+// dbStats 收集默认数据源和所有命名数据源的主库和从库的连接池状态
+func (batis *GoBatis) dbStats() []dbInstance {
+	root := batis
+	if batis.root != nil {
+		root = batis.root
+	}
+	instances := make([]dbInstance, 0)
+	collect := func(name string, db *sql.DB, replicas []*sql.DB) {
+		instances = append(instances, dbInstance{datasource: name, role: "primary", stats: db.Stats()})
+		for i, replica := range replicas {
+			instances = append(instances, dbInstance{datasource: name, role: "replica-" + strconv.Itoa(i), stats: replica.Stats()})
+		}
+	}
+	if db, b := root.db.Interface().(*sql.DB); b {
+		collect("default", db, root.replicas)
+	}
+	names := make([]string, 0, len(root.dataSources))
+	for name := range root.dataSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := root.dataSources[name]
+		collect(name, source.db, source.replicas)
+	}
+	return instances
+}
+
+// writeDBStats 以 Prometheus 文本格式输出连接池状态
+func writeDBStats(w io.Writer, instances []dbInstance) {
+	dbMetrics := []struct {
+		name, kind, help string
+		value            func(s sql.DBStats) string
+	}{
+		{"gobatis_db_max_open_connections", "gauge", "Maximum number of open connections to the database.", func(s sql.DBStats) string { return strconv.Itoa(s.MaxOpenConnections) }},
+		{"gobatis_db_open_connections", "gauge", "The number of established connections both in use and idle.", func(s sql.DBStats) string { return strconv.Itoa(s.OpenConnections) }},
+		{"gobatis_db_in_use_connections", "gauge", "The number of connections currently in use.", func(s sql.DBStats) string { return strconv.Itoa(s.InUse) }},
+		{"gobatis_db_idle_connections", "gauge", "The number of idle connections.", func(s sql.DBStats) string { return strconv.Itoa(s.Idle) }},
+		{"gobatis_db_wait_count_total", "counter", "The total number of connections waited for.", func(s sql.DBStats) string { return strconv.FormatInt(s.WaitCount, 10) }},
+		{"gobatis_db_wait_duration_seconds_total", "counter", "The total time blocked waiting for a new connection.", func(s sql.DBStats) string { return formatFloat(s.WaitDuration.Seconds()) }},
+		{"gobatis_db_max_idle_closed_total", "counter", "The total number of connections closed due to SetMaxIdleConns.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxIdleClosed, 10) }},
+		{"gobatis_db_max_idle_time_closed_total", "counter", "The total number of connections closed due to SetConnMaxIdleTime.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxIdleTimeClosed, 10) }},
+		{"gobatis_db_max_lifetime_closed_total", "counter", "The total number of connections closed due to SetConnMaxLifetime.", func(s sql.DBStats) string { return strconv.FormatInt(s.MaxLifetimeClosed, 10) }},
+	}
+	for _, metric := range dbMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, instance := range instances {
+			fmt.Fprintf(w, "%s{datasource=\"%s\",role=\"%s\"} %s\n", metric.name, labelValue(instance.datasource), instance.role, metric.value(instance.stats))
+		}
+	}
+}
+
+// labelValue 转义 Prometheus 标签值中的反斜杠，双引号和换行
+func labelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,38 @@
+package gobatis
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	run := func(elapsed time.Duration, rows int64, err error) {
+		inv := &Invocation{Namespace: "UserMapper", Id: "Find", Rows: rows}
+		m.Execute(inv, func() error {
+			inv.Elapsed = elapsed
+			return err
+		})
+	}
+	run(3*time.Millisecond, 2, nil)
+	run(200*time.Millisecond, 1, nil)
+	run(time.Millisecond, 0, errors.New("boom"))
+	buf := &strings.Builder{}
+	m.write(buf)
+	out := buf.String()
+	for _, line := range []string{
+		`gobatis_statements_total{namespace="UserMapper",id="Find",outcome="success"} 2`,
+		`gobatis_statements_total{namespace="UserMapper",id="Find",outcome="error"} 1`,
+		`gobatis_statement_rows_total{namespace="UserMapper",id="Find",outcome="success"} 3`,
+		`gobatis_statement_duration_seconds_bucket{namespace="UserMapper",id="Find",outcome="success",le="0.005"} 1`,
+		`gobatis_statement_duration_seconds_bucket{namespace="UserMapper",id="Find",outcome="success",le="0.25"} 2`,
+		`gobatis_statement_duration_seconds_bucket{namespace="UserMapper",id="Find",outcome="success",le="+Inf"} 2`,
+		`gobatis_statement_duration_seconds_count{namespace="UserMapper",id="Find",outcome="error"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package gobatis
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// sortItem 排序字段校验，只允许字段名(可以带表别名)加上可选的 asc/desc
+var sortItem = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*(\s+(?i:asc|desc))?$`)
+
+// PageRequest 分页查询参数，作为 mapper 函数的参数传入时，GoBatis 会根据方言自动追加分页语句
+type PageRequest struct {
+	// Page 页码，从 1 开始
+	Page int
+	// Size 每页记录数
+	Size int
+	// Sort 排序规则，例如 "age desc, id"
+	Sort string
+	// SkipCount 跳过总数统计，Page.Total 和 Page.Pages 将保持零值
+	SkipCount bool
+}
+
+// Page 分页查询结果，mapper 函数返回 Page[T] 时会执行分页查询和总数统计
+type Page[T any] struct {
+	// Items 当前页的数据
+	Items []T
+	// Total 总记录数
+	Total int64
+	// Page 当前页码
+	Page int
+	// Size 每页记录数
+	Size int
+	// Pages 总页数
+	Pages int
+}
+
+// pageResult 用于识别 mapper 函数的 Page[T] 返回值
+type pageResult interface {
+	itemsType() reflect.Type
+	setPage(items any, total int64, req *PageRequest)
+}
+
+func (p *Page[T]) itemsType() reflect.Type {
+	return reflect.TypeOf(p.Items)
+}
+
+func (p *Page[T]) setPage(items any, total int64, req *PageRequest) {
+	p.Items = items.([]T)
+	p.Total = total
+	p.Page = req.Page
+	p.Size = req.Size
+	if req.Size > 0 {
+		p.Pages = int((total + int64(req.Size) - 1) / int64(req.Size))
+	}
+}
+
+// isPage 校验返回值是否为 Page[T]
+func isPage(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(reflect.TypeOf(new(pageResult)).Elem())
+}
+
+// offset 计算分页跳过的记录数
+func (req *PageRequest) offset() int64 {
+	return int64(req.Page-1) * int64(req.Size)
+}
+
+// check 校验分页参数，页码小于 1 时按第一页处理
+func (req *PageRequest) check() error {
+	if req.Size <= 0 {
+		return errors.New("page size must be greater than 0")
+	}
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	return nil
+}
+
+// orderBy 生成排序语句，排序字段只能是字段名，防止通过 Sort 注入 sql
+func (req *PageRequest) orderBy() (string, error) {
+	if strings.TrimSpace(req.Sort) == "" {
+		return "", nil
+	}
+	items := strings.Split(req.Sort, ",")
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if !sortItem.MatchString(item) {
+			return "", fmt.Errorf("page sort '%s' is invalid", item)
+		}
+		items[i] = item
+	}
+	return " ORDER BY " + strings.Join(items, ", "), nil
+}
+
+// pageTail 拆分查询语句最外层末尾的 ORDER BY 子句，子查询和 OVER() 中的排序不受影响
+// 查询语句已经带有 LIMIT，OFFSET，FETCH 分页子句或者 FOR UPDATE 子句时无法再追加分页，返回错误
+func pageTail(templateSql string) (string, string, error) {
+	body, tail, limit := splitTail(templateSql)
+	if limit {
+		return "", "", errors.New("the statement already has a LIMIT/OFFSET/FETCH clause, it can not be paged by PageRequest")
+	}
+	tokens := sqlTokenIndex(tail, false)
+	for i := 0; i+1 < len(tokens); i++ {
+		if strings.EqualFold(tokens[i].text, "for") && strings.EqualFold(tokens[i+1].text, "update") {
+			return "", "", errors.New("the statement has a FOR UPDATE clause, it can not be paged by PageRequest")
+		}
+	}
+	return strings.TrimRight(body, " \t\r\n"), strings.TrimSpace(tail), nil
+}
+
+// hasOrderBy 查询语句最外层是否带有 ORDER BY 子句
+func hasOrderBy(templateSql string) bool {
+	tokens := sqlTokenIndex(templateSql, false)
+	for i := 0; i+1 < len(tokens); i++ {
+		if strings.EqualFold(tokens[i].text, "order") && strings.EqualFold(tokens[i+1].text, "by") {
+			return true
+		}
+	}
+	return false
+}
+
+// pageQuery 根据方言给查询语句追加排序和分页，没有分页参数时返回查询全部数据的分页参数
+// PageRequest.Sort 不为空时替换查询语句末尾的 ORDER BY 子句，否则保留查询语句本身的排序
+func (batis *GoBatis) pageQuery(req *PageRequest, templateSql string, params []any) (*PageRequest, string, []any, error) {
+	if req == nil {
+		// 没有分页参数，查询全部数据作为一页返回
+		return &PageRequest{Page: 1, SkipCount: true}, templateSql, params, nil
+	}
+	if err := req.check(); err != nil {
+		return nil, "", nil, err
+	}
+	body, tail, err := pageTail(templateSql)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	orderBy, err := req.orderBy()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if orderBy == "" && tail != "" {
+		orderBy = " " + tail
+	} else if tail != "" {
+		// 替换掉的排序子句中的参数不再绑定
+		n := 0
+		for _, token := range sqlTokenIndex(tail, true) {
+			if token.text == "?" {
+				n++
+			}
+		}
+		if n > len(params) {
+			n = len(params)
+		}
+		params = params[:len(params)-n]
+	}
+	pageSql, pageParams := batis.Dialect.Page(body+orderBy, req.offset(), int64(req.Size))
+	return req, pageSql, append(append([]any{}, params...), pageParams...), nil
+}
+
+// pageStatement 执行分页查询，templateSql 为 pageQuery 追加了排序和分页之后的查询语句
+// 总数统计使用不带分页的查询语句，参数绑定和查询语句保持一致
+// countTemplate, countParams 为总数统计语句和参数
+func (batis *GoBatis) pageStatement(db, ctx reflect.Value, req *PageRequest, templateSql string, params []any, countTemplate string, countParams []any, result []reflect.Value) (int64, reflect.Value) {
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	var page pageResult
+	if isPage(result[0].Type()) {
+		page = result[0].Addr().Interface().(pageResult)
+	}
+	if page == nil {
+		rows, errType := batis.selectStatement(db, ctx, templateSql, params, result)
+		if !errType.IsZero() || req.SkipCount {
+			return rows, errType
+		}
+		// 兼容 ([]T, int64, error) 形式的返回值，总数写入 int64 返回值
+		return rows, batis.selectCount(db, ctx, countTemplate, countParams, true, result)
+	}
+	items := reflect.New(page.itemsType()).Elem()
+	items.Set(reflect.MakeSlice(items.Type(), 0, 0))
+	rows, errType := batis.selectStatement(db, ctx, templateSql, params, []reflect.Value{items, errType})
+	if !errType.IsZero() {
+		return rows, errType
+	}
+	var total int64
+	if req.Size == 0 {
+		// 查询全部数据
+		req.Size = items.Len()
+		total = int64(items.Len())
+	} else if !req.SkipCount {
+		var err error
+		if total, err = batis.count(db, ctx, countTemplate, countParams); err != nil {
+			return rows, reflect.ValueOf(err)
+		}
+	}
+	page.setPage(items.Interface(), total, req)
+	return rows, errType
+}
+
+// count 执行总数统计语句
+func (batis *GoBatis) count(db, ctx reflect.Value, countSql string, params []any) (int64, error) {
+	var total int64
+	Query := db.MethodByName("QueryContext")
+	call := Query.CallSlice([]reflect.Value{
+		ctx,
+		reflect.ValueOf(batis.Dialect.Rebind(countSql)),
+		reflect.ValueOf(rawParams(params)),
+	})
+	if !call[1].IsZero() {
+		return 0, call[1].Interface().(error)
+	}
+	rows := call[0].Interface().(*sql.Rows)
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&total); err != nil {
+			return 0, err
+		}
+	}
+	return total, rows.Err()
+}
@@ -0,0 +1,98 @@
+package gobatis
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Render 把带 ? 占位符的 sql 模板和参数按照方言渲染为完整的 sql 语句
+// 渲染结果只用于日志输出和调试，不会被执行，执行时始终通过 ? 占位符绑定参数
+// 字符串，引用标识符和注释中的 ? 不会被替换，参数不足时保留 ?
+func Render(dialect Dialect, templateSql string, params []any) string {
+	buf := strings.Builder{}
+	last, n := 0, 0
+	for _, token := range sqlTokenIndex(templateSql, true) {
+		if token.text != "?" {
+			continue
+		}
+		buf.WriteString(templateSql[last:token.index])
+		if n < len(params) {
+			buf.WriteString(Literal(dialect, params[n]))
+		} else {
+			buf.WriteString("?")
+		}
+		n++
+		last = token.index + 1
+	}
+	buf.WriteString(templateSql[last:])
+	return buf.String()
+}
+
+// Literal 把单个参数按照方言渲染为 sql 字面量
+// 支持 nil，所有整数浮点数布尔字符串类型，[]byte，time.Time，driver.Valuer 以及通过 DatabaseType 注册的类型，
+// 其他类型使用 fmt 格式化之后作为字符串字面量
+func Literal(dialect Dialect, value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case Sensitive:
+		return dialect.Quote(sensitiveMask)
+	case string:
+		return dialect.Quote(v)
+	case []byte:
+		if v == nil {
+			return "NULL"
+		}
+		return dialect.Bytes(v)
+	case bool:
+		return dialect.Bool(v)
+	case time.Time:
+		return dialect.Quote(v.Format("2006-01-02 15:04:05.999999999"))
+	case driver.Valuer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return "NULL"
+		}
+		data, err := v.Value()
+		if err != nil {
+			return "?"
+		}
+		return Literal(dialect, data)
+	}
+	if _, b := golangToDatabase[TypeKey(value)]; b {
+		if data, err := dataHandle(value); err == nil {
+			return Literal(dialect, data)
+		}
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "NULL"
+		}
+		return Literal(dialect, rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		return dialect.Bool(rv.Bool())
+	case reflect.String:
+		return dialect.Quote(rv.String())
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if rv.IsNil() {
+				return "NULL"
+			}
+			return dialect.Bytes(rv.Bytes())
+		}
+	}
+	return dialect.Quote(fmt.Sprint(value))
+}
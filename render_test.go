@@ -0,0 +1,100 @@
+package gobatis
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	at := time.Date(2022, 10, 1, 8, 30, 0, 0, time.UTC)
+	params := []any{"it's \\ ok", int64(100), uint32(7), float32(1.5), 0.1, nil, []byte{0xca, 0xfe}, true, at, sql.NullString{}, sql.NullInt64{Int64: 3, Valid: true}}
+	template := "select '?' from t where a = ? and b = ? and c = ? and d = ? and e = ? and f = ? and g = ? and h = ? and i = ? and j = ? and k = ? -- ?"
+	cases := map[Dialect]string{
+		MySQL:      `select '?' from t where a = 'it\'s \\ ok' and b = 100 and c = 7 and d = 1.5 and e = 0.1 and f = NULL and g = X'cafe' and h = TRUE and i = '2022-10-01 08:30:00' and j = NULL and k = 3 -- ?`,
+		PostgreSQL: `select '?' from t where a = 'it''s \ ok' and b = 100 and c = 7 and d = 1.5 and e = 0.1 and f = NULL and g = '\xcafe'::bytea and h = TRUE and i = '2022-10-01 08:30:00' and j = NULL and k = 3 -- ?`,
+		SQLServer:  `select '?' from t where a = N'it''s \ ok' and b = 100 and c = 7 and d = 1.5 and e = 0.1 and f = NULL and g = 0xcafe and h = 1 and i = N'2022-10-01 08:30:00' and j = NULL and k = 3 -- ?`,
+	}
+	for dialect, want := range cases {
+		if got := Render(dialect, template, params); got != want {
+			t.Errorf("Render(%s)\n got: %s\nwant: %s", dialect.Name(), got, want)
+		}
+	}
+}
+
+type sqliteDriver struct{ fakeDriver }
+type pgxDriver struct{ fakeDriver }
+type mssqlDriver struct{ fakeDriver }
+type godrorDriver struct{ fakeDriver }
+
+func TestDialectOf(t *testing.T) {
+	cases := map[driver.Driver]Dialect{
+		fakeDriver{}:   MySQL,
+		sqliteDriver{}: SQLite,
+		pgxDriver{}:    PostgreSQL,
+		mssqlDriver{}:  SQLServer,
+		godrorDriver{}: Oracle,
+	}
+	for d, want := range cases {
+		if got := New((&fakeDB{driver: d}).open()).Dialect; got != want {
+			t.Errorf("%T: got %s, want %s", d, got.Name(), want.Name())
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	template := `select '?', "a?" from t where a = ? and b in (?, ?) -- ?
+and c = ? /* ? */`
+	cases := map[Dialect]string{
+		MySQL: template,
+		PostgreSQL: `select '?', "a?" from t where a = $1 and b in ($2, $3) -- ?
+and c = $4 /* ? */`,
+		SQLServer: `select '?', "a?" from t where a = @p1 and b in (@p2, @p3) -- ?
+and c = @p4 /* ? */`,
+		Oracle: `select '?', "a?" from t where a = :1 and b in (:2, :3) -- ?
+and c = :4 /* ? */`,
+	}
+	for dialect, want := range cases {
+		if got := dialect.Rebind(template); got != want {
+			t.Errorf("Rebind(%s)\n got: %s\nwant: %s", dialect.Name(), got, want)
+		}
+	}
+}
+
+// politic 只实现了旧的 ForEach 接口的迭代扩展
+type politic struct{}
+
+func (politic) ForEach(value any, template string, separator string) (string, string, []any, error) {
+	return "debug", "?, ?", []any{1, 2}, nil
+}
+
+func TestDeprecatedTemplate(t *testing.T) {
+	debug, template, params, err := AnalysisTemplate("select * from t where name = {name} and age = {age}", map[string]any{"name": "it's", "age": 3})
+	if err != nil || debug != `select * from t where name = 'it\'s' and age = 3` || template != "select * from t where name = ? and age = ?" || len(params) != 2 {
+		t.Error(debug, template, params, err)
+	}
+	template, params, err = Combine{Politic: politic{}}.Render()
+	if err != nil || template != "?, ?" || len(params) != 2 {
+		t.Error(template, params, err)
+	}
+	debug, template, _, err = Slice{}.ForEach([]int{1, 2}, "{item}", ",")
+	if err != nil || debug != "1,2" || template != "?,?" {
+		t.Error(debug, template, err)
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	if _, _, err := analysisTemplate("select * from t where id = {id", map[string]any{"id": 1}); err == nil {
+		t.Error("analysisTemplate stray '{'")
+	}
+	if key, err := unTemplate("{a}"); err != nil || key != "a" {
+		t.Error("unTemplate", key, err)
+	}
+	if _, err := unTemplate("ids"); err == nil {
+		t.Error("unTemplate without braces")
+	}
+	if key := UnTemplate("{a}"); key != "a" {
+		t.Error("UnTemplate", key)
+	}
+}
@@ -0,0 +1,85 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+)
+
+// Balancer 从多个从库中选择执行查询的数据库
+type Balancer interface {
+	Pick(replicas []*sql.DB) *sql.DB
+}
+
+// RoundRobin 按顺序轮流使用每一个从库
+type RoundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *RoundRobin) Pick(replicas []*sql.DB) *sql.DB {
+	return replicas[(r.next.Add(1)-1)%uint64(len(replicas))]
+}
+
+// defaultBalancer Balancer 为 nil 时使用的轮询策略
+var defaultBalancer = &RoundRobin{}
+
+// LeastInUse 选择当前正在使用的连接数最少的从库
+type LeastInUse struct{}
+
+func (LeastInUse) Pick(replicas []*sql.DB) *sql.DB {
+	db := replicas[0]
+	inUse := db.Stats().InUse
+	for _, replica := range replicas[1:] {
+		if n := replica.Stats().InUse; n < inUse {
+			db, inUse = replica, n
+		}
+	}
+	return db
+}
+
+// masterKey context 中强制使用主库的标记
+type masterKey struct{}
+
+// UseMaster 返回的 ctx 传给 mapper 函数后，select 语句也会在主库上执行，用于写入之后立即读取的场景
+func UseMaster(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, masterKey{}, true)
+}
+
+// isMaster 检查 ctx 是否要求使用主库
+func isMaster(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	b, _ := ctx.Value(masterKey{}).(bool)
+	return b
+}
+
+// reader 选择 select 语句执行的数据库
+// 配置了从库时，select 语句通过 Balancer 选择从库执行，语句标签 useMaster="true" 或者 ctx 通过 UseMaster 标记时使用主库
+// 在事务中的查询始终在事务所在的主库上执行，不会调用该方法
+func (batis *GoBatis) reader(id []string, ctx reflect.Value) reflect.Value {
+	if len(batis.replicas) == 0 {
+		return batis.db
+	}
+	if c, _ := ctx.Interface().(context.Context); isMaster(c) {
+		return batis.db
+	}
+	if element, err := batis.element(id); err == nil {
+		if attr := element.SelectAttr("useMaster"); attr != nil {
+			if b, _ := strconv.ParseBool(attr.Value); b {
+				return batis.db
+			}
+		}
+	}
+	balancer := batis.Balancer
+	if balancer == nil {
+		// 不回写 batis.Balancer，并发的查询共享同一个 GoBatis
+		balancer = defaultBalancer
+	}
+	return reflect.ValueOf(balancer.Pick(batis.replicas))
+}
@@ -0,0 +1,85 @@
+package gobatis
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type replicaMapper struct {
+	Get       func(ctx context.Context) ([]streamUser, error)
+	GetMaster func(ctx context.Context) ([]streamUser, error)
+	Add       func(ctx context.Context, row map[string]any) (int64, error)
+}
+
+func TestReplica(t *testing.T) {
+	primary, replica := &fakeDB{}, &fakeDB{}
+	batis := New(primary.open(), replica.open())
+	batis.Logger = NopLogger{}
+	loadMapper(t, batis, `
+<mapper namespace="replicaMapper">
+    <select id="Get">select id, name from user</select>
+    <select id="GetMaster" useMaster="true">select id, name from user</select>
+    <insert id="Add">insert into user (name) values ({name})</insert>
+</mapper>`)
+	mapper := &replicaMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+	check := func(name string, primaryQueries, replicaQueries int) {
+		t.Helper()
+		if p, r := len(statements(primary.history(), "query")), len(statements(replica.history(), "query")); p != primaryQueries || r != replicaQueries {
+			t.Errorf("%s: primary %d, replica %d", name, p, r)
+		}
+	}
+
+	mapper.Get(ctx)
+	check("select", 0, 1)
+	mapper.Get(UseMaster(ctx))
+	check("UseMaster", 1, 0)
+	mapper.GetMaster(ctx)
+	check("useMaster", 1, 0)
+
+	// 写入和事务中的查询都在主库上执行
+	mapper.Add(ctx, map[string]any{"name": "a"})
+	if log := replica.history(); len(log) != 0 {
+		t.Errorf("%q", log)
+	}
+	if log := statements(primary.history(), "exec"); len(log) != 1 {
+		t.Errorf("%q", log)
+	}
+	batis.Transaction(ctx, nil, func(ctx context.Context) error {
+		_, err := mapper.Get(ctx)
+		return err
+	})
+	check("transaction", 1, 0)
+}
+
+// TestReplicaBalancer Balancer 为 nil 时并发查询使用默认的轮询策略，不会回写共享的 GoBatis
+func TestReplicaBalancer(t *testing.T) {
+	primary, a, b := &fakeDB{}, &fakeDB{}, &fakeDB{}
+	batis := New(primary.open(), a.open(), b.open())
+	batis.Logger = NopLogger{}
+	batis.Balancer = nil
+	loadMapper(t, batis, `
+<mapper namespace="replicaMapper">
+    <select id="Get">select id, name from user</select>
+</mapper>`)
+	mapper := &replicaMapper{}
+	batis.ScanMappers(mapper)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mapper.Get(context.Background())
+		}()
+	}
+	wg.Wait()
+	if batis.Balancer != nil {
+		t.Error("reader must not write back the default balancer")
+	}
+	ra, rb := len(statements(a.history(), "query")), len(statements(b.history(), "query"))
+	if ra+rb != 8 || ra == 0 || rb == 0 || len(primary.history()) != 0 {
+		t.Errorf("replica a %d, replica b %d, primary %q", ra, rb, primary.history())
+	}
+}
@@ -0,0 +1,134 @@
+package gobatis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// RetryPolicy 瞬时错误(死锁，序列化失败等)的重试策略
+// 只有 GoBatis 自己管理事务的语句(没有调用方事务时的单条语句和批量执行)以及 Transaction 的整个闭包会被重试，
+// 在调用方传入的 *sql.Tx，WithTx 或者 Transaction 闭包中执行的单条语句不会被单独重试
+type RetryPolicy struct {
+	// MaxAttempts 最多执行的次数(包括第一次)，小于等于 1 表示不重试
+	MaxAttempts int
+	// Backoff 第 attempt 次执行失败之后等待多久再重试，nil 时使用 ExponentialBackoff(10ms, 1s)
+	Backoff func(attempt int) time.Duration
+	// Retryable 判断错误是否可以重试，nil 时使用 IsTransient
+	Retryable func(err error) bool
+}
+
+// ExponentialBackoff 指数退避，第 attempt 次失败之后等待 base * 2^(attempt-1)，最多等待 max，max 小于等于 0 表示不限制
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		wait := base
+		for i := 1; i < attempt && (max <= 0 || wait < max); i++ {
+			wait *= 2
+		}
+		if max > 0 && wait > max {
+			wait = max
+		}
+		return wait
+	}
+}
+
+// IsTransient 默认的重试判断，识别错误链中的
+// MySQL 1213(死锁) 1205(锁等待超时)，PostgreSQL SQLSTATE 40001(序列化失败) 40P01(死锁)，SQL Server 1205(死锁)
+// 驱动的错误类型通过反射识别，不依赖具体的驱动包
+func IsTransient(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if state, b := err.(interface{ SQLState() string }); b {
+			switch state.SQLState() {
+			case "40001", "40P01":
+				return true
+			}
+		}
+		if number, b := err.(interface{ SQLErrorNumber() int32 }); b && number.SQLErrorNumber() == 1205 {
+			return true
+		}
+		value := reflect.ValueOf(err)
+		if value.Kind() == reflect.Pointer {
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			continue
+		}
+		name := strings.ToLower(value.Type().PkgPath() + "." + value.Type().Name())
+		switch {
+		case strings.Contains(name, "mysql"):
+			// github.com/go-sql-driver/mysql.MySQLError{Number uint16}
+			if number := value.FieldByName("Number"); number.IsValid() && number.CanUint() {
+				if n := number.Uint(); n == 1213 || n == 1205 {
+					return true
+				}
+			}
+		case strings.HasSuffix(value.Type().PkgPath(), "/pq"):
+			// github.com/lib/pq.Error{Code ErrorCode}
+			if code := value.FieldByName("Code"); code.IsValid() && code.Kind() == reflect.String {
+				if c := code.String(); c == "40001" || c == "40P01" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// retryPolicy 返回语句的重试策略，语句标签的 retry 属性(最多执行次数)和 retryBackoff 属性(指数退避的初始等待时间)覆盖 GoBatis.Retry
+func (batis *GoBatis) retryPolicy(element *etree.Element) (RetryPolicy, error) {
+	policy := batis.Retry
+	if attr := element.SelectAttr("retry"); attr != nil && attr.Value != "" {
+		attempts, err := strconv.Atoi(attr.Value)
+		if err != nil || attempts < 0 {
+			return policy, fmt.Errorf("%s,%s,retry '%s' is not a valid attempt count", element.Tag, element.SelectAttrValue("id", ""), attr.Value)
+		}
+		policy.MaxAttempts = attempts
+	}
+	if attr := element.SelectAttr("retryBackoff"); attr != nil && attr.Value != "" {
+		backoff, err := time.ParseDuration(attr.Value)
+		if err != nil || backoff < 0 {
+			return policy, fmt.Errorf("%s,%s,retryBackoff '%s' is not a valid duration", element.Tag, element.SelectAttrValue("id", ""), attr.Value)
+		}
+		policy.Backoff = ExponentialBackoff(backoff, 0)
+	}
+	return policy, nil
+}
+
+// retry 按照策略执行 call，call 返回可重试的错误时调用 reset 清理本次执行的状态(例如回滚自动开启的事务)，等待之后再次执行
+// ctx 被取消时停止等待并返回最后一次执行的错误
+func (batis *GoBatis) retry(ctx context.Context, policy RetryPolicy, name string, call func() error, reset func()) error {
+	retryable, backoff := policy.Retryable, policy.Backoff
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	if backoff == nil {
+		backoff = ExponentialBackoff(10*time.Millisecond, time.Second)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+		if reset != nil {
+			reset()
+		}
+		wait := backoff(attempt)
+		batis.log(ctx, LogWarn, "sql retry", Field{Key: "statement", Value: name}, Field{Key: "attempt", Value: attempt}, Field{Key: "wait", Value: wait}, Field{Key: "error", Value: err})
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type stateError string
+
+func (e stateError) Error() string    { return "state " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+func TestRetry(t *testing.T) {
+	if !IsTransient(fmt.Errorf("update,%w", stateError("40001"))) || IsTransient(stateError("23505")) || IsTransient(errors.New("deadlock")) {
+		t.Fatal("IsTransient")
+	}
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 3: 40 * time.Millisecond, 10: 50 * time.Millisecond} {
+		if got := backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+	batis := &GoBatis{Logger: NopLogger{}}
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: func(int) time.Duration { return 0 }}
+	calls, resets := 0, 0
+	err := batis.retry(context.Background(), policy, "t", func() error {
+		if calls++; calls < 3 {
+			return stateError("40P01")
+		}
+		return nil
+	}, func() { resets++ })
+	if err != nil || calls != 3 || resets != 2 {
+		t.Fatal(err, calls, resets)
+	}
+	calls = 0
+	err = batis.retry(context.Background(), policy, "t", func() error {
+		calls++
+		return stateError("23505")
+	}, nil)
+	if err == nil || calls != 1 {
+		t.Fatal(err, calls)
+	}
+}
+
+type retryMapper struct {
+	Remove    func(ctx context.Context) (int64, error)
+	RemoveAll func(ctx context.Context, ids []int64) (int64, error)
+}
+
+func TestRetryTimeout(t *testing.T) {
+	attempts := 0
+	db := &fakeDB{exec: func(ctx context.Context, _ string, _ []any) (driver.Result, error) {
+		if attempts++; attempts == 1 {
+			// 第一次执行用完超时，返回可重试的错误
+			<-ctx.Done()
+			return nil, stateError("40001")
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return driver.RowsAffected(1), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="retryMapper">
+    <delete id="Remove" timeout="20ms" retry="2">delete from user</delete>
+</mapper>`)
+	batis.Retry.Backoff = func(int) time.Duration { return 0 }
+	mapper := &retryMapper{}
+	batis.ScanMappers(mapper)
+	// 超时作用于每一次执行，重试时重新计算
+	if count, err := mapper.Remove(context.Background()); err != nil || count != 1 || attempts != 2 {
+		t.Fatal(count, err, attempts)
+	}
+}
+
+// TestRetryCommit 自动开启的事务在 COMMIT 时返回可重试的错误，整个语句重新执行
+func TestRetryCommit(t *testing.T) {
+	failures := 0
+	db := &fakeDB{}
+	db.commit = func() error {
+		if failures > 0 {
+			failures--
+			return stateError("40001")
+		}
+		return nil
+	}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="retryMapper">
+    <delete id="Remove" retry="2">delete from user</delete>
+    <delete id="RemoveAll" retry="2">delete from user where id = {item}</delete>
+</mapper>`)
+	batis.Retry.Backoff = func(int) time.Duration { return 0 }
+	var results []int64
+	batis.Use(hooks{result: func(inv *Invocation) error {
+		results = append(results, inv.Rows)
+		return nil
+	}})
+	mapper := &retryMapper{}
+	batis.ScanMappers(mapper)
+
+	failures = 1
+	if count, err := mapper.Remove(context.Background()); err != nil || count != 1 {
+		t.Fatal(count, err)
+	}
+	want := []string{"begin", "exec delete from user []", "commit", "begin", "exec delete from user []", "commit"}
+	if log := db.history(); !reflect.DeepEqual(log, want) {
+		t.Fatalf("%q", log)
+	}
+
+	failures = 1
+	if count, err := mapper.RemoveAll(context.Background(), []int64{1, 2}); err != nil || count != 2 {
+		t.Fatal(count, err)
+	}
+	if log := db.history(); len(statements(log, "begin")) != 2 || len(statements(log, "exec")) != 4 || log[len(log)-1] != "commit" {
+		t.Fatalf("%q", log)
+	}
+
+	// 重试次数用完之后返回提交的错误，AfterResult 在每次提交之前调用
+	failures, results = 2, nil
+	var state stateError
+	if _, err := mapper.Remove(context.Background()); !errors.As(err, &state) || state != "40001" {
+		t.Fatal(err)
+	}
+	if log := db.history(); len(statements(log, "commit")) != 2 || len(statements(log, "rollback")) != 0 {
+		t.Fatalf("%q", log)
+	}
+	if !reflect.DeepEqual(results, []int64{1, 1}) {
+		t.Error(results)
+	}
+}
@@ -0,0 +1,60 @@
+package gobatis
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/beevik/etree"
+)
+
+// ErrNoRows 严格模式下单行查询没有匹配的记录，errors.Is(err, sql.ErrNoRows) 同样成立
+var ErrNoRows = fmt.Errorf("gobatis: %w", sql.ErrNoRows)
+
+// ErrTooManyRows 严格模式下单行查询返回了多条记录
+var ErrTooManyRows = errors.New("gobatis: more than one row in result set")
+
+// strict 返回查询语句是否使用严格的单行语义，语句标签的 strict 属性优先，其次是 GoBatis.Strict
+func (batis *GoBatis) strict(element *etree.Element) (bool, error) {
+	if attr := element.SelectAttr("strict"); attr != nil && attr.Value != "" {
+		b, err := strconv.ParseBool(attr.Value)
+		if err != nil {
+			return false, fmt.Errorf("%s,%s,strict '%s' is not a bool", element.Tag, element.SelectAttrValue("id", ""), attr.Value)
+		}
+		return b, nil
+	}
+	return batis.Strict, nil
+}
+
+// isPresence 校验返回值是否是 (T, bool, error) 形式，bool 表示是否查询到记录
+func isPresence(result []reflect.Value) bool {
+	return len(result) == 3 && result[0].Kind() != reflect.Slice && result[1].Kind() == reflect.Bool
+}
+
+// singleRow 处理单行查询的结果，rows 为结果集的行数
+// 没有记录时指针返回值为 nil，(T, bool, error) 形式的 bool 返回值表示是否查询到记录，
+// 严格模式下没有记录返回 ErrNoRows((T, bool, error) 形式除外)，多条记录返回 ErrTooManyRows
+func singleRow(strict bool, rows int64, result []reflect.Value) reflect.Value {
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	if result[0].Kind() == reflect.Slice {
+		return errType
+	}
+	presence := isPresence(result)
+	if presence {
+		result[1].SetBool(rows > 0)
+	}
+	if rows == 0 {
+		if result[0].Kind() == reflect.Pointer {
+			result[0].Set(reflect.Zero(result[0].Type()))
+		}
+		if strict && !presence {
+			return reflect.ValueOf(ErrNoRows)
+		}
+	}
+	if rows > 1 && strict {
+		return reflect.ValueOf(ErrTooManyRows)
+	}
+	return errType
+}
@@ -0,0 +1,38 @@
+package gobatis
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSingleRow(t *testing.T) {
+	type User struct{ Id int }
+	errT := reflect.TypeOf(new(error)).Elem()
+	ptr := []reflect.Value{reflect.New(reflect.TypeOf(&User{})).Elem(), reflect.New(errT).Elem()}
+	ptr[0].Set(reflect.ValueOf(&User{}))
+	if err := singleRow(false, 0, ptr); !err.IsZero() || !ptr[0].IsNil() {
+		t.Fatal("nil pointer", err)
+	}
+	if err := errOf(singleRow(true, 0, ptr)); !errors.Is(err, ErrNoRows) || !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	if err := errOf(singleRow(true, 2, ptr)); err != ErrTooManyRows {
+		t.Fatal(err)
+	}
+	if err := singleRow(false, 2, ptr); !err.IsZero() {
+		t.Fatal("non strict", err)
+	}
+	presence := []reflect.Value{reflect.New(reflect.TypeOf(User{})).Elem(), reflect.New(reflect.TypeOf(true)).Elem(), reflect.New(errT).Elem()}
+	if err := singleRow(true, 0, presence); !err.IsZero() || presence[1].Bool() {
+		t.Fatal("presence", err)
+	}
+	if err := singleRow(true, 1, presence); !err.IsZero() || !presence[1].Bool() {
+		t.Fatal("presence", err)
+	}
+	slice := []reflect.Value{reflect.New(reflect.TypeOf([]User{})).Elem(), reflect.New(errT).Elem()}
+	if err := singleRow(true, 0, slice); !err.IsZero() {
+		t.Fatal("slice", err)
+	}
+}
@@ -0,0 +1,185 @@
+package gobatis
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// sensitiveMask 敏感数据输出时的替代文本
+const sensitiveMask = "***"
+
+// sensitiveMark 上下文 map 中记录敏感字段的 key，值为 sensitiveKeys
+const sensitiveMark = "$sensitive"
+
+// sensitiveKeys 上下文 map 中的敏感字段名称
+type sensitiveKeys []string
+
+// Sensitive 敏感参数
+// 字段上配置了 gobatis:"sensitive" 标签，或者 key 匹配 GoBatis.SensitiveKeys 的上下文数据，解析模板时会被包装为 Sensitive，
+// sql 日志，Render 渲染的 sql，fmt 格式化以及 json 序列化都只输出 ***，执行语句时使用原始数据
+type Sensitive struct {
+	value any
+}
+
+// Raw 返回原始数据
+func (s Sensitive) Raw() any {
+	return s.value
+}
+
+func (s Sensitive) String() string {
+	return sensitiveMask
+}
+
+func (s Sensitive) Format(f fmt.State, _ rune) {
+	io.WriteString(f, sensitiveMask)
+}
+
+func (s Sensitive) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + sensitiveMask + `"`), nil
+}
+
+// Value 直接把 Sensitive 作为参数传给 database/sql 时使用原始数据
+func (s Sensitive) Value() (driver.Value, error) {
+	return driver.DefaultParameterConverter.ConvertValue(s.value)
+}
+
+// gobatisTag gobatis 标签中是否包含 option，标签可以用逗号分隔多个选项，例如 gobatis:"sensitive" 和 gobatis:"version"
+func gobatisTag(tag, option string) bool {
+	for _, value := range strings.Split(tag, ",") {
+		if strings.TrimSpace(value) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitive 上下文中 keys 指向的数据是否为敏感数据
+// 敏感字段记录在最后一个 key 所在的 map 中，for 标签迭代的元素 map 同样保留了这些记录
+func sensitive(ctx map[string]any, keys []string) bool {
+	if len(keys) == 0 {
+		return false
+	}
+	for _, k := range keys[:len(keys)-1] {
+		var b bool
+		if ctx, b = ctx[k].(map[string]any); !b {
+			return false
+		}
+	}
+	marks, _ := ctx[sensitiveMark].(sensitiveKeys)
+	for _, mark := range marks {
+		if mark == keys[len(keys)-1] {
+			return true
+		}
+	}
+	return false
+}
+
+// markSensitive 把上下文中 key 匹配 patterns 的字段记录为敏感字段，匹配不区分大小写，pattern 语法和 path.Match 相同
+func markSensitive(ctx map[string]any, patterns []string) {
+	if len(patterns) == 0 || ctx == nil {
+		return
+	}
+	marks, _ := ctx[sensitiveMark].(sensitiveKeys)
+	// 复制一份，避免修改共享的记录
+	marks = append(sensitiveKeys{}, marks...)
+	for key, value := range ctx {
+		if key == sensitiveMark {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(key)); ok {
+				marks = append(marks, key)
+				break
+			}
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			markSensitive(v, patterns)
+		case []map[string]any:
+			for _, item := range v {
+				markSensitive(item, patterns)
+			}
+		}
+	}
+	if len(marks) > 0 {
+		ctx[sensitiveMark] = marks
+	}
+}
+
+// maskParams 把参数全部包装为 Sensitive
+func maskParams(params []any) []any {
+	for i, param := range params {
+		if _, b := param.(Sensitive); !b {
+			params[i] = Sensitive{value: param}
+		}
+	}
+	return params
+}
+
+// rawParams 执行语句之前取出 Sensitive 中的原始数据，没有敏感参数时返回 params 本身
+func rawParams(params []any) []any {
+	var values []any
+	for i, param := range params {
+		if s, b := param.(Sensitive); b {
+			if values == nil {
+				values = append([]any{}, params...)
+			}
+			values[i] = s.value
+		}
+	}
+	if values == nil {
+		return params
+	}
+	return values
+}
+
+// sensitiveError 错误信息中的敏感参数被替换为 ***，Unwrap 返回原始错误
+type sensitiveError struct {
+	err error
+	msg string
+}
+
+func (e *sensitiveError) Error() string {
+	return e.msg
+}
+
+func (e *sensitiveError) Unwrap() error {
+	return e.err
+}
+
+// maskError 把数据库返回的错误信息中出现的敏感参数替换为 ***，例如唯一约束冲突时驱动输出的重复值
+func maskError(err error, params []any) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, param := range params {
+		s, b := param.(Sensitive)
+		if !b {
+			continue
+		}
+		var text string
+		switch v := s.value.(type) {
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		case nil:
+			continue
+		default:
+			text = fmt.Sprint(v)
+		}
+		// 过短的数据容易误伤错误信息中的其他内容
+		if len(text) < 3 {
+			continue
+		}
+		msg = strings.ReplaceAll(msg, text, sensitiveMask)
+	}
+	if msg == err.Error() {
+		return err
+	}
+	return &sensitiveError{err: err, msg: msg}
+}
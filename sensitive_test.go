@@ -0,0 +1,52 @@
+package gobatis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSensitive(t *testing.T) {
+	type account struct {
+		Name     string
+		Password string `gobatis:"sensitive"`
+		Token    string
+	}
+	ctx := toMap(map[string]any{
+		"user":  account{Name: "alice", Password: "p@ss", Token: "tk-1"},
+		"users": []account{{Name: "bob", Password: "b0b!"}},
+	})
+	markSensitive(ctx, []string{"*TOKEN*"})
+	templateSql, params, err := analysisTemplate("insert into t values ({user.name}, {user.password}, {user.token})", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[alice *** ***]"; fmt.Sprint(params) != want {
+		t.Fatalf("params got %v, want %s", params, want)
+	}
+	if got, want := Render(MySQL, templateSql, params), "insert into t values ('alice', '***', '***')"; got != want {
+		t.Fatalf("Render got %s, want %s", got, want)
+	}
+	if raw := rawParams(params); raw[1] != "p@ss" || raw[2] != "tk-1" {
+		t.Fatal(raw)
+	}
+	_, params, err = analysisForTemplate("({item.name}, {item.password})", ctx["users"].([]map[string]any)[0], nil)
+	if err != nil || fmt.Sprint(params) != "[bob ***]" {
+		t.Fatal(params, err)
+	}
+	cause := errors.New("duplicate entry 'p@ss' for key 'password'")
+	masked := maskError(cause, []any{"alice", Sensitive{value: "p@ss"}})
+	if masked.Error() != "duplicate entry '***' for key 'password'" || !errors.Is(masked, cause) {
+		t.Fatal(masked)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	params := []any{"alice", int64(3), nil, []byte("abc"), Sensitive{value: "p@ss"}}
+	if got := fmt.Sprint(redacted(params, false)); got != "[<string> <int64> <nil> <3 bytes> ***]" {
+		t.Error(got)
+	}
+	if got := fmt.Sprint(redacted(params, true)); got != "[alice 3 <nil> <3 bytes> ***]" {
+		t.Error(got)
+	}
+}
@@ -0,0 +1,252 @@
+package gobatis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+)
+
+// ShardPolicy 语句上下文中没有分片键时的处理策略
+type ShardPolicy int
+
+const (
+	// ShardReject 没有分片键时返回错误
+	ShardReject ShardPolicy = iota
+	// ShardFanOut 没有分片键的查询在 ShardRule.Nodes 的所有分片上执行并合并结果，写入语句仍然返回错误
+	ShardFanOut
+)
+
+// ShardNode 一个分片，DataSource 为 AddDataSource 注册的数据源名称，为空时使用语句本身配置的数据源，Table 为物理表名
+type ShardNode struct {
+	DataSource string
+	Table      string
+}
+
+// ShardRule 分片规则
+type ShardRule struct {
+	// Table 逻辑表名，sql 中出现该表名的语句都会按照规则路由
+	Table string
+	// Key 分片键表达式，在语句上下文中求值，语法和 if 标签的 expr 相同，例如 {userId}
+	Key string
+	// Route 根据分片键的值返回分片
+	Route func(key any) (ShardNode, error)
+	// Nodes 所有分片，Policy 为 ShardFanOut 时查询在这些分片上执行
+	Nodes []ShardNode
+	// Policy 没有分片键时的处理策略
+	Policy ShardPolicy
+}
+
+// shardRule 注册之后的分片规则，key 为编译好的分片键表达式
+type shardRule struct {
+	ShardRule
+	key *vm.Program
+}
+
+// shardRoute 一次路由的结果，tables 为逻辑表名到物理表名的映射
+type shardRoute struct {
+	source string
+	tables map[string]string
+}
+
+// Shard 注册分片规则，规则不完整或者分片键表达式无法编译时 panic
+func (batis *GoBatis) Shard(rule ShardRule) {
+	if rule.Table == "" || rule.Key == "" || rule.Route == nil {
+		Panic("shard rule requires Table, Key and Route")
+	}
+	if rule.Policy == ShardFanOut && len(rule.Nodes) == 0 {
+		Panic("shard rule '" + rule.Table + "' fan-out requires Nodes")
+	}
+	program, err := expr.Compile(AnalysisExpr(rule.Key))
+	if err != nil {
+		Panic("shard rule '"+rule.Table+"' key ", err.Error())
+	}
+	batis.shards = append(batis.shards, &shardRule{ShardRule: rule, key: program})
+}
+
+// route 按照分片规则路由 sql 模板，没有匹配的规则时返回 nil，分片没有指定数据源时路由到 batis 当前的数据源
+// 上下文中有分片键时返回唯一的路由，没有分片键并且规则允许 fan-out 时为每个分片返回一个路由
+// 一条语句匹配多个规则时，所有规则都需要有分片键，并且路由到同一个数据源
+func (batis *GoBatis) route(templateSql string, ctx map[string]any) ([]shardRoute, error) {
+	if len(batis.shards) == 0 {
+		return nil, nil
+	}
+	var routes []shardRoute
+	for _, rule := range batis.shards {
+		if !rule.match(templateSql) {
+			continue
+		}
+		if ctx == nil {
+			ctx = map[string]any{}
+		}
+		key, err := expr.Run(rule.key, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("shard table '%s' key '%s',%s", rule.Table, rule.Key, err.Error())
+		}
+		if key == nil {
+			if rule.Policy != ShardFanOut {
+				return nil, fmt.Errorf("shard table '%s' key '%s' not found", rule.Table, rule.Key)
+			}
+			if routes != nil {
+				return nil, fmt.Errorf("shard table '%s' key '%s' not found, fan-out can not be combined with other shard tables", rule.Table, rule.Key)
+			}
+			for _, node := range rule.Nodes {
+				routes = append(routes, shardRoute{source: batis.nodeSource(node), tables: map[string]string{rule.Table: node.Table}})
+			}
+			continue
+		}
+		node, err := rule.Route(key)
+		if err != nil {
+			return nil, fmt.Errorf("shard table '%s' route,%s", rule.Table, err.Error())
+		}
+		source := batis.nodeSource(node)
+		switch {
+		case routes == nil:
+			routes = []shardRoute{{source: source, tables: map[string]string{rule.Table: node.Table}}}
+		case len(routes) == 1 && routes[0].source == source:
+			routes[0].tables[rule.Table] = node.Table
+		default:
+			return nil, fmt.Errorf("shard table '%s' routed to data source '%s', other shard tables of the statement are routed elsewhere", rule.Table, source)
+		}
+	}
+	return routes, nil
+}
+
+// nodeSource 分片所在的数据源名称
+func (batis *GoBatis) nodeSource(node ShardNode) string {
+	if node.DataSource == "" {
+		return batis.source
+	}
+	return node.DataSource
+}
+
+// match sql 中是否引用了规则的逻辑表
+func (rule *shardRule) match(templateSql string) bool {
+	for _, token := range tableTokens(templateSql) {
+		if strings.EqualFold(token.text, rule.Table) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite 把 sql 中的逻辑表名替换为物理表名，只替换 tableTokens 识别的表名位置，同名的字段，字符串和注释中的内容不变
+func (route shardRoute) rewrite(templateSql string) string {
+	buf := strings.Builder{}
+	last := 0
+	for _, token := range tableTokens(templateSql) {
+		for logical, physical := range route.tables {
+			if strings.EqualFold(token.text, logical) {
+				buf.WriteString(templateSql[last:token.index])
+				buf.WriteString(physical)
+				last = token.index + len(token.text)
+				break
+			}
+		}
+	}
+	buf.WriteString(templateSql[last:])
+	return buf.String()
+}
+
+// tableTokens 返回 sql 中可能是表名的位置
+// FROM，JOIN，INTO，UPDATE 之后的名称(schema.table 取最后一段)，FROM 之后逗号分隔的表，以及 table.column 形式中 . 之前的名称
+func tableTokens(templateSql string) []sqlToken {
+	var tables []sqlToken
+	prev := ""
+	// from FROM 子句的表列表中，alias 为当前表之后的别名单词数
+	from, alias := false, 0
+	for _, token := range sqlTokenIndex(templateSql, true) {
+		word := strings.ToLower(token.text)
+		names := strings.Split(token.text, ".")
+		switch {
+		case prev == "from" || prev == "join" || prev == "into" || prev == "update" || prev == "," && from:
+			// 表名位置 只取最后一段，前面的是 schema
+			name := names[len(names)-1]
+			tables = append(tables, sqlToken{text: name, index: token.index + len(token.text) - len(name)})
+			from, alias = prev == "from" || prev == ",", 0
+		default:
+			if from && word != "," {
+				alias++
+				if alias > 2 || tableClause[word] {
+					from = false
+				}
+			}
+			// table.column 形式 最后一段是字段名
+			index := token.index
+			for _, name := range names[:len(names)-1] {
+				tables = append(tables, sqlToken{text: name, index: index})
+				index += len(name) + 1
+			}
+		}
+		prev = word
+	}
+	return tables
+}
+
+// tableClause 结束 FROM 表列表的关键字
+var tableClause = map[string]bool{
+	"where": true, "on": true, "using": true, "join": true, "inner": true, "left": true, "right": true, "full": true, "cross": true, "natural": true,
+	"group": true, "order": true, "having": true, "limit": true, "offset": true, "fetch": true, "for": true, "union": true, "intersect": true, "except": true,
+	"set": true, "values": true, "select": true, "returning": true, "window": true,
+}
+
+// routed 返回路由之后执行语句的 GoBatis
+func (batis *GoBatis) routed(route shardRoute) (*GoBatis, error) {
+	if route.source == batis.source {
+		return batis, nil
+	}
+	return batis.on(route.source)
+}
+
+// conn 选择语句执行的数据库，显式传入的 *sql.Tx 参数优先，其次是 ctx 中当前数据源的事务，都没有时使用当前数据源并自动开启事务
+func (batis *GoBatis) conn(args *Arguments, explicit bool) {
+	if explicit {
+		return
+	}
+	args.DB, args.Auto = batis.db, true
+	c, _ := args.Ctx.Interface().(context.Context)
+	if state := txFrom(c, batis.source); state != nil {
+		args.DB = reflect.ValueOf(state.tx)
+		args.Auto = false
+	}
+}
+
+// fanOutStatement 在所有分片上执行查询，结果按照分片的顺序合并，返回所有分片的总行数
+// 只支持返回切片和 Each 回调两种形式，分页，总数统计和迭代器无法跨分片合并
+func (batis *GoBatis) fanOutStatement(id []string, routes []shardRoute, args *Arguments, explicit bool, templateSql string, params []any, results []reflect.Value) (int64, reflect.Value) {
+	var total int64
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	if args.Page != nil || !args.Each.IsValid() && (len(results) != 2 || results[0].Kind() != reflect.Slice) {
+		return 0, reflect.ValueOf(fmt.Errorf("shard fan-out query only supports slice result or each callback"))
+	}
+	for _, route := range routes {
+		source, err := batis.routed(route)
+		if err != nil {
+			return total, reflect.ValueOf(err)
+		}
+		part := *args
+		source.conn(&part, explicit)
+		db := part.DB
+		if part.Auto {
+			db = source.reader(id, args.Ctx)
+		}
+		shardSql := route.rewrite(templateSql)
+		var rows int64
+		if args.Each.IsValid() {
+			rows, errType = source.eachStatement(db, args.Ctx, shardSql, params, args.Each)
+		} else {
+			partResults := []reflect.Value{reflect.New(results[0].Type()).Elem(), reflect.New(results[1].Type()).Elem()}
+			if rows, errType = source.selectStatement(db, args.Ctx, shardSql, params, partResults); errType.IsZero() {
+				results[0].Set(reflect.AppendSlice(results[0], partResults[0]))
+			}
+		}
+		total += rows
+		if !errType.IsZero() {
+			return total, errType
+		}
+	}
+	return total, errType
+}
@@ -0,0 +1,138 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShardRewrite(t *testing.T) {
+	route := shardRoute{tables: map[string]string{"events": "events_07"}}
+	cases := map[string]string{
+		"select * from events where uid = ?":                 "select * from events_07 where uid = ?",
+		"select e.id from app.events e where e.uid = ?":      "select e.id from app.events_07 e where e.uid = ?",
+		"select events.id from EVENTS where name = 'events'": "select events_07.id from events_07 where name = 'events'",
+		"select * from events_log where uid = ?":             "select * from events_log where uid = ?",
+		"insert into events (uid) values (?) -- events":      "insert into events_07 (uid) values (?) -- events",
+		// 和表同名的字段不会被替换
+		"select events, x.events from events x where events = ?":                  "select events, x.events from events_07 x where events = ?",
+		"update app.events set events = events + 1 where id = ?":                  "update app.events_07 set events = events + 1 where id = ?",
+		"select * from users u, events e join tags on tags.events = e.id":         "select * from users u, events_07 e join tags on tags.events = e.id",
+		"select * from users where id in (select uid from events) and events = ?": "select * from users where id in (select uid from events_07) and events = ?",
+	}
+	for sql, want := range cases {
+		if got := route.rewrite(sql); got != want {
+			t.Errorf("rewrite(%q)\n got: %q\nwant: %q", sql, got, want)
+		}
+	}
+	rule := &shardRule{ShardRule: ShardRule{Table: "events"}}
+	if rule.match("select events from users where events > ?") {
+		t.Error("column named like the table must not match")
+	}
+	if !rule.match("delete from events where id = ?") || !rule.match("select events.id from app.events") {
+		t.Error("table must match")
+	}
+}
+
+type shardMapper struct {
+	Get    func(ctx context.Context, arg map[string]any) ([]streamUser, error)
+	All    func(ctx context.Context) ([]streamUser, error)
+	Each   func(ctx context.Context, each func(streamUser) error) error
+	Add    func(ctx context.Context, arg map[string]any) (int64, error)
+	Remove func(ctx context.Context, arg map[string]any) (int64, error)
+	Logs   func(ctx context.Context) ([]streamUser, error)
+}
+
+func TestShardStatement(t *testing.T) {
+	rows := func(id int64, name string) func(context.Context, string, []any) (*fakeRows, error) {
+		return func(context.Context, string, []any) (*fakeRows, error) {
+			return newRows([]string{"id", "name"}, []driver.Value{id, name}), nil
+		}
+	}
+	root, a, b := &fakeDB{}, &fakeDB{query: rows(1, "a")}, &fakeDB{query: rows(2, "b")}
+	batis := newFakeBatis(t, root, `
+<mapper namespace="shardMapper">
+    <select id="Get">select id, name from events where uid = {uid}</select>
+    <select id="All">select id, name from events</select>
+    <select id="Each">select id, name from events</select>
+    <insert id="Add">insert into events (uid, name) values ({uid}, {name})</insert>
+    <delete id="Remove">delete from events where name = {name}</delete>
+    <select id="Logs">select id, name from logs</select>
+</mapper>`)
+	batis.AddDataSource("a", a.open())
+	batis.AddDataSource("b", b.open())
+	nodes := []ShardNode{{DataSource: "a", Table: "events_0"}, {DataSource: "b", Table: "events_1"}}
+	batis.Shard(ShardRule{
+		Table: "events",
+		Key:   "{uid}",
+		Route: func(key any) (ShardNode, error) {
+			return nodes[key.(int)%2], nil
+		},
+		Nodes:  nodes,
+		Policy: ShardFanOut,
+	})
+	batis.Shard(ShardRule{
+		Table: "logs",
+		Key:   "{uid}",
+		Route: func(any) (ShardNode, error) {
+			return ShardNode{Table: "logs_0"}, nil
+		},
+	})
+	mapper := &shardMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+
+	// 分片键在上下文中求值，按照 Route 的结果替换表名并选择数据源
+	if users, err := mapper.Get(ctx, map[string]any{"uid": 2}); err != nil || len(users) != 1 || users[0].Name != "a" {
+		t.Fatal(users, err)
+	}
+	if users, err := mapper.Get(ctx, map[string]any{"uid": 3}); err != nil || len(users) != 1 || users[0].Name != "b" {
+		t.Fatal(users, err)
+	}
+	if log := a.history(); !reflect.DeepEqual(log, []string{"query select id, name from events_0 where uid = ? [2]"}) {
+		t.Errorf("a: %q", log)
+	}
+	if log := b.history(); !reflect.DeepEqual(log, []string{"query select id, name from events_1 where uid = ? [3]"}) {
+		t.Errorf("b: %q", log)
+	}
+	if _, err := mapper.Add(ctx, map[string]any{"uid": 5, "name": "e"}); err != nil {
+		t.Fatal(err)
+	}
+	if log := b.history(); !reflect.DeepEqual(log, []string{"begin", "exec insert into events_1 (uid, name) values (?, ?) [5 e]", "commit"}) {
+		t.Errorf("b: %q", log)
+	}
+
+	// 没有分片键时在所有分片上查询，结果按照 Nodes 的顺序合并
+	users, err := mapper.All(ctx)
+	if err != nil || len(users) != 2 || users[0].Name != "a" || users[1].Name != "b" {
+		t.Fatal(users, err)
+	}
+	if la, lb := a.history(), b.history(); !reflect.DeepEqual(la, []string{"query select id, name from events_0 []"}) || !reflect.DeepEqual(lb, []string{"query select id, name from events_1 []"}) {
+		t.Errorf("a: %q, b: %q", la, lb)
+	}
+	var names []string
+	err = mapper.Each(ctx, func(user streamUser) error {
+		names = append(names, user.Name)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatal(names, err)
+	}
+	a.history()
+	b.history()
+	if _, err = mapper.Remove(ctx, map[string]any{"name": "e"}); err == nil || !strings.Contains(err.Error(), "fan-out is only supported for select") {
+		t.Error(err)
+	}
+
+	// ShardReject 没有分片键时返回错误，不会执行任何语句
+	if _, err = mapper.Logs(ctx); err == nil || !strings.Contains(err.Error(), "shard table 'logs' key '{uid}' not found") {
+		t.Error(err)
+	}
+	for _, db := range []*fakeDB{root, a, b} {
+		if log := db.history(); len(log) != 0 {
+			t.Errorf("%q", log)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+//go:build go1.21
+
+package gobatis
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogLogger 通过 log/slog 输出日志
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger 使用 slog.Logger 创建 Logger，logger 为 nil 时使用 slog.Default()
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return l.logger.Enabled(ctx, slogLevel(level))
+}
+
+func (l slogLogger) Log(ctx context.Context, level LogLevel, msg string, fields ...Field) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attrs := make([]slog.Attr, len(fields))
+	for i, field := range fields {
+		attrs[i] = slog.Any(field.Key, field.Value)
+	}
+	l.logger.LogAttrs(ctx, slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogDebug:
+		return slog.LevelDebug
+	case LogWarn:
+		return slog.LevelWarn
+	case LogError:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
@@ -0,0 +1,88 @@
+package gobatis
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// StatementError 的执行阶段
+const (
+	// PhaseRender 解析参数，渲染 sql 模板以及执行之前的准备工作
+	PhaseRender = "render"
+	// PhaseExecute 执行语句，提交或者回滚事务
+	PhaseExecute = "execute"
+	// PhaseScan 扫描结果集到返回值
+	PhaseScan = "scan"
+)
+
+// StatementError mapper 函数返回的错误，Err 为原始错误，可以通过 errors.Is 和 errors.As 判断
+type StatementError struct {
+	Namespace string
+	Id        string
+	// Phase 出错的阶段 PhaseRender，PhaseExecute 或者 PhaseScan
+	Phase string
+	// Sql 渲染之后的 sql 模板，参数为 ? 占位符，渲染失败时为空
+	Sql string
+	Err error
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("%s.%s %s: %v", e.Namespace, e.Id, e.Phase, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// scanError 标记结果集扫描阶段产生的错误
+type scanError struct {
+	err error
+}
+
+func (e *scanError) Error() string {
+	return e.err.Error()
+}
+
+func (e *scanError) Unwrap() error {
+	return e.err
+}
+
+// panicError 把 mapper 调用过程中的 panic 转换为 error，panic 的值是 error 时可以通过 errors.Is 判断
+func panicError(p any) error {
+	if err, b := p.(error); b {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return fmt.Errorf("panic: %v", p)
+}
+
+// statementError 把 mapper 函数返回的错误包装为 *StatementError，已经是 *StatementError 的错误保持不变
+func statementError(id []string, phase string, inv *Invocation, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, b := err.(*StatementError); b {
+		return err
+	}
+	var scan *scanError
+	if errors.As(err, &scan) {
+		phase = PhaseScan
+	}
+	e := &StatementError{Namespace: id[0], Id: id[1], Phase: phase, Err: err}
+	if inv != nil {
+		e.Sql = inv.Sql
+	}
+	return e
+}
+
+// wrapStatementError 把返回值中的错误替换为 *StatementError
+func wrapStatementError(id []string, phase string, inv *Invocation, results []reflect.Value) {
+	out := results[len(results)-1]
+	if !out.IsValid() || out.Type() != reflect.TypeOf(new(error)).Elem() {
+		// 最后一个返回值不是 error
+		return
+	}
+	if err := errOf(out); err != nil {
+		out.Set(reflect.ValueOf(statementError(id, phase, inv, err)))
+	}
+}
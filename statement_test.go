@@ -0,0 +1,146 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatementError(t *testing.T) {
+	inv := &Invocation{Sql: "select * from t where id = ?"}
+	err := statementError([]string{"UserMapper", "Find"}, PhaseExecute, inv, &scanError{err: sql.ErrNoRows})
+	var se *StatementError
+	if !errors.As(err, &se) || se.Phase != PhaseScan || se.Sql != inv.Sql || !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	if err.Error() != "UserMapper.Find scan: sql: no rows in result set" {
+		t.Error(err.Error())
+	}
+	if statementError([]string{"UserMapper", "Find"}, PhaseRender, nil, err) != err {
+		t.Error("StatementError wrapped twice")
+	}
+	if err = panicError(sql.ErrConnDone); !errors.Is(err, sql.ErrConnDone) {
+		t.Error(err)
+	}
+}
+
+type errorMapper struct {
+	Render func(ctx context.Context, args map[string]any) ([]streamUser, error)
+	Query  func(ctx context.Context) ([]streamUser, error)
+	Scan   func(ctx context.Context) ([]streamUser, error)
+	Add    func(ctx context.Context, args map[string]any) (int64, error)
+	Remove func(ctx context.Context) (int64, error)
+}
+
+// panicAfter 在 id 语句的 AfterResult 中 panic
+type panicAfter struct {
+	BaseInterceptor
+	id string
+}
+
+func (p panicAfter) AfterResult(inv *Invocation) error {
+	if inv.Id == p.id {
+		panic("after result")
+	}
+	return nil
+}
+
+func TestMapperStatementError(t *testing.T) {
+	lost := errors.New("connection lost")
+	db := &fakeDB{
+		query: func(_ context.Context, query string, _ []any) (*fakeRows, error) {
+			if strings.Contains(query, "scan") {
+				return newRows([]string{"id", "name"}, []driver.Value{"abc", "a"}), nil
+			}
+			return nil, lost
+		},
+		exec: func(context.Context, string, []any) (driver.Result, error) {
+			panic("driver panic")
+		},
+	}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="errorMapper">
+    <select id="Render">select id, name from user where id = {id</select>
+    <select id="Query">select id, name from user</select>
+    <select id="Scan">select id, name from scan</select>
+    <insert id="Add">insert into user (name) values ({name})</insert>
+    <delete id="Remove">delete from user</delete>
+</mapper>`)
+	batis.Use(panicAfter{id: "Remove"})
+	mapper := &errorMapper{}
+	batis.ScanMappers(mapper)
+	ctx := context.Background()
+	phase := func(err error, want string) *StatementError {
+		t.Helper()
+		var se *StatementError
+		if !errors.As(err, &se) || se.Namespace != "errorMapper" || se.Phase != want {
+			t.Fatalf("want %s StatementError, got %v", want, err)
+		}
+		return se
+	}
+
+	_, err := mapper.Render(ctx, map[string]any{"id": 1})
+	phase(err, PhaseRender)
+	_, err = mapper.Query(ctx)
+	if se := phase(err, PhaseExecute); se.Sql != "select id, name from user" || !errors.Is(err, lost) {
+		t.Error(se.Sql, err)
+	}
+	_, err = mapper.Scan(ctx)
+	phase(err, PhaseScan)
+	db.history()
+
+	// 驱动中的 panic 被恢复为错误，自动开启的事务回滚
+	_, err = mapper.Add(ctx, map[string]any{"name": "a"})
+	if se := phase(err, PhaseExecute); !strings.Contains(se.Error(), "panic: driver panic") {
+		t.Error(err)
+	}
+	if log := db.history(); log[0] != "begin" || log[len(log)-1] != "rollback" || len(statements(log, "commit")) != 0 {
+		t.Fatalf("%q", log)
+	}
+	// 执行之外(AfterResult)的 panic 由 mapper 函数恢复，同样回滚自动开启的事务
+	db.exec = nil
+	_, err = mapper.Remove(ctx)
+	if se := phase(err, PhaseExecute); !strings.Contains(se.Error(), "panic: after result") {
+		t.Error(err)
+	}
+	if log := db.history(); !reflect.DeepEqual(log, []string{"begin", "exec delete from user []", "rollback"}) {
+		t.Fatalf("%q", log)
+	}
+}
+
+type noErrorMapper struct {
+	List  func(ctx context.Context) ([]streamUser, int64)
+	Typed func(ctx context.Context) ([]streamUser, *StatementError)
+}
+
+// TestMapperWithoutError 最后一个返回值不是 error 接口时无法返回 panic 的错误，panic 原样抛给调用方
+func TestMapperWithoutError(t *testing.T) {
+	db := &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) {
+		return newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}), nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="noErrorMapper">
+    <select id="List">select id, name from user</select>
+    <select id="Typed">select id, name from user</select>
+</mapper>`)
+	mapper := &noErrorMapper{}
+	batis.ScanMappers(mapper)
+	batis.Use(panicAfter{id: "List"}, panicAfter{id: "Typed"})
+	for name, call := range map[string]func(){
+		"List":  func() { mapper.List(context.Background()) },
+		"Typed": func() { mapper.Typed(context.Background()) },
+	} {
+		func() {
+			defer func() {
+				if p := recover(); p != "after result" {
+					t.Errorf("%s: want the original panic, got %v", name, p)
+				}
+			}()
+			call()
+		}()
+	}
+}
@@ -0,0 +1,129 @@
+package gobatis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// errStopSeq 迭代器调用方提前结束迭代
+var errStopSeq = errors.New("seq stop")
+
+// eachStatement 流式查询，逐行扫描结果集并交给 mapper 函数定义的回调处理，结果集不会在内存中完整保留
+// each 回调函数的形式为 func(T) error，回调返回错误或者 ctx 被取消时立即停止扫描并返回该错误
+func (batis *GoBatis) eachStatement(db, ctx reflect.Value, templateSql string, params []any, each reflect.Value) (int64, reflect.Value) {
+	errType := reflect.New(reflect.TypeOf(new(error)).Elem()).Elem()
+	count, err := stream(db, ctx, batis.Dialect.Rebind(templateSql), params, each.Type().In(0), func(value reflect.Value) error {
+		call := each.Call([]reflect.Value{value})
+		if !call[0].IsNil() {
+			return call[0].Interface().(error)
+		}
+		return nil
+	})
+	if err != nil {
+		return int64(count), reflect.ValueOf(err)
+	}
+	return int64(count), errType
+}
+
+// seqStatement 创建一个 seqType 类型的迭代器，查询在迭代开始时才会执行，每次迭代都会经过拦截器的 Execute，
+// 迭代结束(包括调用方提前结束)之后调用 AfterResult，此时 Rows 为实际交给调用方的行数
+// 查询，扫描或者 AfterResult 失败时，会把 *StatementError 通过迭代器的第二个参数交给调用方，并结束迭代，调用方提前结束迭代时不再传递错误
+// timeout 从每次迭代开始时计算
+func (batis *GoBatis) seqStatement(inv *Invocation, db, ctx reflect.Value, timeout time.Duration, seqType reflect.Type) reflect.Value {
+	yieldType := seqType.In(0)
+	elemType := yieldType.In(0)
+	nilErr := reflect.Zero(yieldType.In(1))
+	return reflect.MakeFunc(seqType, func(args []reflect.Value) []reflect.Value {
+		yield := args[0]
+		run := *inv
+		ctx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = withTimeout(ctx, timeout)
+			defer cancel()
+			run.Context, _ = ctx.Interface().(context.Context)
+		}
+		stopped := false
+		err := batis.execute(&run, func() error {
+			count, err := stream(db, ctx, batis.Dialect.Rebind(run.Sql), run.Params, elemType, func(value reflect.Value) error {
+				if !yield.Call([]reflect.Value{value, nilErr})[0].Bool() {
+					return errStopSeq
+				}
+				return nil
+			})
+			run.Rows = int64(count)
+			if err == errStopSeq {
+				// 提前结束的那一行已经交给了调用方
+				stopped = true
+				run.Rows++
+				return nil
+			}
+			return err
+		})
+		batis.afterResult(&run, err)
+		if run.Err != nil && !stopped {
+			yieldErr := reflect.New(yieldType.In(1)).Elem()
+			yieldErr.Set(reflect.ValueOf(statementError([]string{run.Namespace, run.Id}, PhaseExecute, &run, run.Err)))
+			yield.Call([]reflect.Value{reflect.Zero(elemType), yieldErr})
+		}
+		return nil
+	})
+}
+
+// stream 执行查询并逐行扫描，每扫描一行就调用一次 fn，返回成功处理的行数
+// fn 返回错误，或者 ctx 被取消，都会停止扫描，sql.Rows 始终会被关闭
+func stream(db, ctx reflect.Value, templateSql string, params []any, elemType reflect.Type, fn func(reflect.Value) error) (int, error) {
+	count := 0
+	Query := db.MethodByName("QueryContext")
+	call := Query.CallSlice([]reflect.Value{
+		ctx,
+		reflect.ValueOf(templateSql),
+		reflect.ValueOf(rawParams(params)),
+	})
+	if !call[1].IsZero() {
+		return count, call[1].Interface().(error)
+	}
+	row := call[0]
+	defer row.MethodByName("Close").Call(nil)
+	scanner, err := newRowScanner(row, resultElem(elemType).Interface())
+	if err != nil {
+		return count, err
+	}
+	c := ctx.Interface().(context.Context)
+	for scanner.Next() {
+		if err = c.Err(); err != nil {
+			return count, err
+		}
+		value, err := scanner.Scan()
+		if err != nil {
+			return count, err
+		}
+		if err = fn(value); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// isSeq 校验返回值是否是 func(yield func(T, error) bool) 形式的迭代器，iter.Seq2[T, error] 满足该形式
+func isSeq(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 0 {
+		return false
+	}
+	yield := t.In(0)
+	if yield.Kind() != reflect.Func || yield.NumIn() != 2 || yield.NumOut() != 1 {
+		return false
+	}
+	return yield.In(1) == reflect.TypeOf(new(error)).Elem() && yield.Out(0).Kind() == reflect.Bool
+}
+
+// isEach 校验参数是否是 func(T) error 形式的结果集回调函数
+func isEach(t reflect.Type) bool {
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() != 1 {
+		return false
+	}
+	return t.Out(0) == reflect.TypeOf(new(error)).Elem()
+}
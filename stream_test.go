@@ -0,0 +1,87 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+type streamUser struct {
+	Id   int64  `column:"id"`
+	Name string `column:"name"`
+}
+
+type streamMapper struct {
+	Each func(ctx context.Context, each func(streamUser) error) error
+	Seq  func(ctx context.Context) (func(yield func(*streamUser, error) bool), error)
+}
+
+// afterRows 记录每次 AfterResult 调用时的行数
+type afterRows struct {
+	BaseInterceptor
+	rows *[]int64
+}
+
+func (a afterRows) AfterResult(inv *Invocation) error {
+	*a.rows = append(*a.rows, inv.Rows)
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	var rows *fakeRows
+	db := &fakeDB{query: func(context.Context, string, []any) (*fakeRows, error) {
+		rows = newRows([]string{"id", "name"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"}, []driver.Value{int64(3), "c"})
+		return rows, nil
+	}}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="streamMapper">
+    <select id="Each">select id, name from user</select>
+    <select id="Seq">select id, name from user</select>
+</mapper>`)
+	var after []int64
+	batis.Use(afterRows{rows: &after})
+	mapper := &streamMapper{}
+	batis.ScanMappers(mapper)
+
+	stop := errors.New("stop")
+	var names []string
+	err := mapper.Each(context.Background(), func(u streamUser) error {
+		if names = append(names, u.Name); len(names) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || len(names) != 2 || rows.next != 2 || db.closed != 1 {
+		t.Fatal(err, names, rows.next, db.closed)
+	}
+
+	db.history()
+	seq, err := mapper.Seq(context.Background())
+	if err != nil || len(statements(db.history(), "query")) != 0 {
+		t.Fatal("the query runs when iterating", err)
+	}
+	if len(after) != 1 {
+		t.Fatal("AfterResult must wait for the iteration", after)
+	}
+	names = nil
+	seq(func(u *streamUser, err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, u.Name)
+		return false
+	})
+	if len(names) != 1 || rows.next != 1 || db.closed != 2 {
+		t.Fatal(names, rows.next, db.closed)
+	}
+	if len(after) != 2 || after[1] != 1 {
+		t.Fatal(after)
+	}
+	seq(func(u *streamUser, err error) bool {
+		return err == nil
+	})
+	if len(after) != 3 || after[2] != 3 {
+		t.Fatal(after)
+	}
+}
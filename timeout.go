@@ -0,0 +1,41 @@
+package gobatis
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// timeout 返回语句的执行超时，语句标签的 timeout 属性优先，其次是 GoBatis.Timeout，0 表示不设置超时
+// timeout 属性可以是 time.ParseDuration 支持的格式，例如 500ms，也可以是整数秒
+func (batis *GoBatis) timeout(element *etree.Element) (time.Duration, error) {
+	attr := element.SelectAttr("timeout")
+	if attr == nil || attr.Value == "" {
+		return batis.Timeout, nil
+	}
+	if seconds, err := strconv.Atoi(attr.Value); err == nil {
+		if seconds < 0 {
+			return 0, fmt.Errorf("%s,%s,timeout '%s' is negative", element.Tag, element.SelectAttrValue("id", ""), attr.Value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(attr.Value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("%s,%s,timeout '%s' is not a valid duration", element.Tag, element.SelectAttrValue("id", ""), attr.Value)
+	}
+	return timeout, nil
+}
+
+// withTimeout 为 mapper 函数的 ctx 参数设置超时，ctx 本身的截止时间更早时以 ctx 为准
+func withTimeout(ctx reflect.Value, timeout time.Duration) (reflect.Value, context.CancelFunc) {
+	c, _ := ctx.Interface().(context.Context)
+	if c == nil {
+		c = context.Background()
+	}
+	c, cancel := context.WithTimeout(c, timeout)
+	return reflect.ValueOf(c), cancel
+}
@@ -0,0 +1,52 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+type timeoutMapper struct {
+	Get    func(ctx context.Context) ([]streamUser, error)
+	Remove func(ctx context.Context) (int64, error)
+}
+
+func TestTimeout(t *testing.T) {
+	block := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return errors.New("the statement was not cancelled")
+		}
+	}
+	db := &fakeDB{
+		query: func(ctx context.Context, _ string, _ []any) (*fakeRows, error) {
+			return nil, block(ctx)
+		},
+		exec: func(ctx context.Context, _ string, _ []any) (driver.Result, error) {
+			return nil, block(ctx)
+		},
+	}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="timeoutMapper">
+    <select id="Get" timeout="20ms">select id, name from user</select>
+    <delete id="Remove">delete from user</delete>
+</mapper>`)
+	mapper := &timeoutMapper{}
+	batis.ScanMappers(mapper)
+
+	if _, err := mapper.Get(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	// GoBatis.Timeout 作用于没有配置 timeout 属性的语句，超时之后自动开启的事务不会提交
+	batis.Timeout = 20 * time.Millisecond
+	if _, err := mapper.Remove(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if log := db.history(); len(statements(log, "begin")) != 1 || len(statements(log, "commit")) != 0 {
+		t.Fatalf("%q", log)
+	}
+}
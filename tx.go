@@ -0,0 +1,261 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// txKey context 中保存事务状态的 key，每个数据源的事务互相独立，source 为数据源名称
+type txKey struct {
+	source string
+}
+
+// txState Transaction 开启的事务状态，通过 context 传递给闭包内的 mapper 函数调用
+type txState struct {
+	tx *sql.Tx
+	// savepoints 已经创建的保存点数量，用于生成嵌套事务的保存点名称
+	savepoints int
+	// managed 事务由 Transaction 开启并提交，WithTx 放入的事务为 false
+	managed bool
+	// afterCommit 事务提交成功之后执行，例如清空查询缓存，回写乐观锁的版本号，嵌套事务回滚到保存点时丢弃保存点之后添加的回调
+	afterCommit []func()
+}
+
+// txFrom 取出 context 中 source 数据源的事务
+func txFrom(ctx context.Context, source string) *txState {
+	if ctx == nil {
+		return nil
+	}
+	if state, b := ctx.Value(txKey{source: source}).(*txState); b {
+		return state
+	}
+	return nil
+}
+
+// afterCommit 语句在 Transaction 开启的事务中执行时，把 fn 推迟到事务提交成功之后执行并返回 true，事务回滚时不会执行
+// 调用方自己管理的事务(显式传入的 *sql.Tx 或者 WithTx)无法感知提交，返回 false，由调用方立即处理
+func (batis *GoBatis) afterCommit(ctx context.Context, explicit bool, fn func()) bool {
+	if explicit {
+		return false
+	}
+	state := txFrom(ctx, batis.source)
+	if state == nil || !state.managed {
+		return false
+	}
+	state.afterCommit = append(state.afterCommit, fn)
+	return true
+}
+
+// WithTx 把调用方在默认数据源上开启的事务放入 ctx，接收该 ctx 的 mapper 函数调用会在 tx 中执行，不会自动提交或回滚
+// mapper 函数显式传入的 *sql.Tx 参数优先于 ctx 中的事务
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return WithNamedTx(ctx, "", tx)
+}
+
+// WithNamedTx 把调用方在 name 数据源上开启的事务放入 ctx，只有在该数据源上执行的 mapper 函数会加入这个事务
+func WithNamedTx(ctx context.Context, name string, tx *sql.Tx) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, txKey{source: name}, &txState{tx: tx})
+}
+
+// TxFromContext 取出 ctx 中默认数据源的事务，没有时返回 nil
+func TxFromContext(ctx context.Context) *sql.Tx {
+	return NamedTxFromContext(ctx, "")
+}
+
+// NamedTxFromContext 取出 ctx 中 name 数据源的事务，没有时返回 nil
+func NamedTxFromContext(ctx context.Context, name string) *sql.Tx {
+	if state := txFrom(ctx, name); state != nil {
+		return state.tx
+	}
+	return nil
+}
+
+// Transaction 在事务中执行 fn，opts 为 nil 时使用 GoBatis.TxOptions，fn 返回 nil 时提交事务，返回错误或者发生 panic 时回滚事务(panic 会在回滚之后继续抛出)
+// fn 中接收 ctx 参数的 mapper 函数调用会自动加入该事务
+// 在 fn 中嵌套调用 Transaction 时，数据库支持保存点的情况下嵌套事务使用 SAVEPOINT 实现，嵌套的 fn 返回错误只回滚到保存点，
+// 不支持保存点时嵌套调用直接加入外层事务，opts 对嵌套调用无效
+// Transaction 只作用于默认数据源，其他数据源使用 TransactionOn
+// 配置了 GoBatis.Retry 时，fn 或者提交返回可重试的错误会在新的事务中重新执行整个 fn，fn 需要可以重复执行，嵌套调用不会单独重试
+func (batis *GoBatis) Transaction(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if state := txFrom(ctx, batis.source); state != nil {
+		return batis.nested(ctx, state, fn)
+	}
+	if opts == nil {
+		opts = batis.TxOptions
+	}
+	// 整个闭包按照重试策略重新执行，每次都开启新的事务
+	return batis.retry(ctx, batis.Retry, "transaction", func() error {
+		return batis.transaction(ctx, opts, fn)
+	}, nil)
+}
+
+// transaction 开启事务执行一次 fn
+func (batis *GoBatis) transaction(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context) error) (err error) {
+	db := batis.db.Interface().(*sql.DB)
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	state := &txState{tx: tx, managed: true}
+	if err = fn(context.WithValue(ctx, txKey{source: batis.source}, state)); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w,rollback error,%s", err, rollbackErr.Error())
+		}
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	for _, call := range state.afterCommit {
+		call()
+	}
+	return nil
+}
+
+// TransactionOn 在 name 数据源上执行 Transaction，fn 中只有在该数据源上执行的 mapper 函数会加入事务
+func (batis *GoBatis) TransactionOn(ctx context.Context, name string, opts *sql.TxOptions, fn func(ctx context.Context) error) error {
+	source, err := batis.on(name)
+	if err != nil {
+		return err
+	}
+	return source.Transaction(ctx, opts, fn)
+}
+
+// nested 嵌套事务，通过保存点实现局部回滚
+func (batis *GoBatis) nested(ctx context.Context, state *txState, fn func(ctx context.Context) error) (err error) {
+	state.savepoints++
+	name := fmt.Sprintf("gobatis_sp_%d", state.savepoints)
+	save, rollback, release := batis.Dialect.Savepoint(name)
+	if save == "" {
+		return fn(ctx)
+	}
+	if _, err = state.tx.ExecContext(ctx, save); err != nil {
+		return err
+	}
+	callbacks := len(state.afterCommit)
+	defer func() {
+		if p := recover(); p != nil {
+			state.tx.ExecContext(ctx, rollback)
+			state.afterCommit = state.afterCommit[:callbacks]
+			panic(p)
+		}
+	}()
+	if err = fn(ctx); err != nil {
+		state.afterCommit = state.afterCommit[:callbacks]
+		if _, rollbackErr := state.tx.ExecContext(ctx, rollback); rollbackErr != nil {
+			return fmt.Errorf("%w,rollback to savepoint error,%s", err, rollbackErr.Error())
+		}
+		return err
+	}
+	if release != "" {
+		_, err = state.tx.ExecContext(ctx, release)
+	}
+	return err
+}
+
+// txConfig mapper 函数字段上通过 isolation，readOnly 标签配置的事务选项
+type txConfig struct {
+	isolation string
+	readOnly  string
+}
+
+// txTag 解析 mapper 函数字段上的事务标签，标签值不合法时返回错误
+func txTag(field reflect.StructField) (txConfig, error) {
+	config := txConfig{isolation: field.Tag.Get("isolation"), readOnly: field.Tag.Get("readOnly")}
+	return config, config.apply(&sql.TxOptions{})
+}
+
+// apply 把配置的事务选项写入 opts，未配置的选项保持不变
+func (config txConfig) apply(opts *sql.TxOptions) error {
+	if config.isolation != "" {
+		level, err := isolationLevel(config.isolation)
+		if err != nil {
+			return err
+		}
+		opts.Isolation = level
+	}
+	if config.readOnly != "" {
+		readOnly, err := strconv.ParseBool(config.readOnly)
+		if err != nil {
+			return fmt.Errorf("readOnly '%s' is not a bool", config.readOnly)
+		}
+		opts.ReadOnly = readOnly
+	}
+	return nil
+}
+
+// isolationLevel 解析事务隔离级别，不区分大小写，单词之间可以使用空格，下划线或者中划线分隔，例如 serializable，READ_COMMITTED
+func isolationLevel(name string) (sql.IsolationLevel, error) {
+	name = strings.NewReplacer("_", " ", "-", " ").Replace(strings.TrimSpace(name))
+	for level := sql.LevelDefault; level <= sql.LevelLinearizable; level++ {
+		if strings.EqualFold(level.String(), name) {
+			return level, nil
+		}
+	}
+	return sql.LevelDefault, fmt.Errorf("unknown isolation level '%s'", name)
+}
+
+// txOptions mapper 函数自动开启事务时使用的事务选项
+// 优先级从高到低依次为 语句标签的 isolation，readOnly 属性，mapper 函数字段的 isolation，readOnly 标签，GoBatis.TxOptions
+func (batis *GoBatis) txOptions(id []string) (*sql.TxOptions, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return nil, err
+	}
+	config, b := batis.txConfigs[strings.Join(id, ".")]
+	isolation, readOnly := element.SelectAttr("isolation"), element.SelectAttr("readOnly")
+	if !b && isolation == nil && readOnly == nil {
+		return batis.TxOptions, nil
+	}
+	opts := &sql.TxOptions{}
+	if batis.TxOptions != nil {
+		*opts = *batis.TxOptions
+	}
+	if err = config.apply(opts); err != nil {
+		return nil, err
+	}
+	attr := txConfig{}
+	if isolation != nil {
+		attr.isolation = isolation.Value
+	}
+	if readOnly != nil {
+		attr.readOnly = readOnly.Value
+	}
+	if err = attr.apply(opts); err != nil {
+		return nil, fmt.Errorf("%s,%s,%s", element.Tag, id[1], err.Error())
+	}
+	return opts, nil
+}
+
+// autoCommit 单条 insert，update，delete 语句是否不开启事务直接在 *sql.DB 上执行(数据库自动提交)
+// 语句标签的 autoCommit 属性优先，没有配置时使用 GoBatis.AutoCommit
+func (batis *GoBatis) autoCommit(id []string) (bool, error) {
+	element, err := batis.element(id)
+	if err != nil {
+		return false, err
+	}
+	if attr := element.SelectAttr("autoCommit"); attr != nil && attr.Value != "" {
+		b, err := strconv.ParseBool(attr.Value)
+		if err != nil {
+			return false, fmt.Errorf("%s,%s,autoCommit '%s' is not a bool", element.Tag, id[1], attr.Value)
+		}
+		return b, nil
+	}
+	return batis.AutoCommit, nil
+}
@@ -0,0 +1,138 @@
+package gobatis
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIsolationLevel(t *testing.T) {
+	cases := map[string]sql.IsolationLevel{
+		"serializable":     sql.LevelSerializable,
+		"READ_COMMITTED":   sql.LevelReadCommitted,
+		"repeatable-read":  sql.LevelRepeatableRead,
+		"Read Uncommitted": sql.LevelReadUncommitted,
+		"default":          sql.LevelDefault,
+	}
+	for name, want := range cases {
+		level, err := isolationLevel(name)
+		if err != nil || level != want {
+			t.Errorf("%s: got %v, %v", name, level, err)
+		}
+	}
+	if _, err := isolationLevel("chaos"); err == nil {
+		t.Error("chaos: expected error")
+	}
+}
+
+type txMapper struct {
+	Add func(ctx context.Context, row map[string]any) (int64, error)
+}
+
+func TestTransaction(t *testing.T) {
+	db := &fakeDB{}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="txMapper">
+    <insert id="Add">insert into user (name) values ({name})</insert>
+</mapper>`)
+	mapper := &txMapper{}
+	batis.ScanMappers(mapper)
+	add := func(ctx context.Context, name string) error {
+		_, err := mapper.Add(ctx, map[string]any{"name": name})
+		return err
+	}
+
+	// 嵌套的 fn 返回错误只回滚到保存点，外层事务继续提交
+	err := batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+		if err := add(ctx, "a"); err != nil {
+			return err
+		}
+		if err := batis.Transaction(ctx, nil, func(ctx context.Context) error {
+			if err := add(ctx, "b"); err != nil {
+				return err
+			}
+			return errors.New("skip b")
+		}); err == nil {
+			t.Error("expected nested error")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"begin", "exec insert into user (name) values (?) [a]",
+		"exec SAVEPOINT gobatis_sp_1 []", "exec insert into user (name) values (?) [b]", "exec ROLLBACK TO SAVEPOINT gobatis_sp_1 []",
+		"commit"}
+	if log := db.history(); !reflect.DeepEqual(log, want) {
+		t.Fatalf("%q", log)
+	}
+
+	if err = batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+		add(ctx, "a")
+		return errors.New("abort")
+	}); err == nil || err.Error() != "abort" {
+		t.Fatal(err)
+	}
+	if log := db.history(); log[len(log)-1] != "rollback" || len(statements(log, "commit")) != 0 {
+		t.Fatalf("%q", log)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Error("panic must be rethrown after rollback", p)
+			}
+		}()
+		batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+			panic("boom")
+		})
+	}()
+	if log := db.history(); !reflect.DeepEqual(log, []string{"begin", "rollback"}) {
+		t.Fatalf("%q", log)
+	}
+}
+
+type autoCommitMapper struct {
+	Add    func(ctx context.Context, row map[string]any) (int64, error)
+	AddTx  func(ctx context.Context, row map[string]any) (int64, error)
+	Remove func(ctx context.Context, row map[string]any) (int64, error)
+}
+
+func TestAutoCommit(t *testing.T) {
+	db := &fakeDB{}
+	batis := newFakeBatis(t, db, `
+<mapper namespace="autoCommitMapper">
+    <insert id="Add" autoCommit="true">insert into user (name) values ({name})</insert>
+    <insert id="AddTx" autoCommit="false">insert into user (name) values ({name})</insert>
+    <delete id="Remove">delete from user where name = {name}</delete>
+</mapper>`)
+	mapper := &autoCommitMapper{}
+	batis.ScanMappers(mapper)
+	row := map[string]any{"name": "a"}
+
+	if _, err := mapper.Add(context.Background(), row); err != nil {
+		t.Fatal(err)
+	}
+	if log := db.history(); !reflect.DeepEqual(log, []string{"exec insert into user (name) values (?) [a]"}) {
+		t.Fatalf("%q", log)
+	}
+	if _, err := mapper.Remove(context.Background(), row); err != nil {
+		t.Fatal(err)
+	}
+	if log := db.history(); len(log) != 3 || log[0] != "begin" || log[2] != "commit" {
+		t.Fatalf("%q", log)
+	}
+
+	// GoBatis.AutoCommit 作用于没有配置 autoCommit 属性的语句，语句标签的属性优先
+	batis.AutoCommit = true
+	mapper.Remove(context.Background(), row)
+	if log := db.history(); !reflect.DeepEqual(log, []string{"exec delete from user where name = ? [a]"}) {
+		t.Fatalf("%q", log)
+	}
+	mapper.AddTx(context.Background(), row)
+	if log := db.history(); len(log) != 3 || log[0] != "begin" || log[2] != "commit" {
+		t.Fatalf("%q", log)
+	}
+}
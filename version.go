@@ -0,0 +1,125 @@
+package gobatis
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// ErrOptimisticLock 乐观锁更新失败，记录已经被其他事务修改或者删除，自动开启的事务会被回滚
+var ErrOptimisticLock = errors.New("optimistic lock failed, the record has been modified or deleted")
+
+// versionLock update 语句参数中配置了 gobatis:"version" 标签的版本号字段
+type versionLock struct {
+	// target 版本号字段，参数以指针传入时可以回写
+	target reflect.Value
+	column string
+}
+
+// versionOf 在 mapper 函数的参数中找到第一个带有版本号字段的结构体
+func versionOf(values []reflect.Value) (*versionLock, error) {
+	for _, value := range values {
+		lock, err := versionField(value)
+		if lock != nil || err != nil {
+			return lock, err
+		}
+	}
+	return nil, nil
+}
+
+// versionField 结构体中配置了 gobatis:"version" 标签的字段，版本号字段只支持整数类型，数据库字段为 column 标签或者字段名的蛇形命名
+func versionField(value reflect.Value) (*versionLock, error) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() || !gobatisTag(field.Tag.Get("gobatis"), "version") {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, fmt.Errorf("version field '%s' must be an integer, got %s", field.Name, field.Type.String())
+		}
+		column := field.Tag.Get("column")
+		if column == "" {
+			column = strcase.ToSnake(field.Name)
+		}
+		return &versionLock{target: value.Field(i), column: column}, nil
+	}
+	return nil, nil
+}
+
+// rewrite 在 update 语句的 SET 子句开头追加 version = version + 1，在 WHERE 条件之后追加 AND version = ?
+// 原有的 WHERE 条件会被括号包裹，末尾的 ORDER BY / LIMIT 子句保持在最后，版本号参数插入到对应的位置
+func (lock *versionLock) rewrite(templateSql string, params []any) (string, []any, error) {
+	body, tail, _ := splitTail(templateSql)
+	set, where := -1, -1
+	for _, token := range sqlTokenIndex(body, false) {
+		switch strings.ToLower(token.text) {
+		case "set":
+			if set == -1 {
+				set = token.index + len(token.text)
+			}
+		case "where":
+			if set != -1 && where == -1 {
+				where = token.index + len(token.text)
+			}
+		}
+	}
+	if set == -1 {
+		return "", nil, fmt.Errorf("version column '%s',update statement has no SET clause", lock.column)
+	}
+	buf := strings.Builder{}
+	buf.WriteString(body[:set])
+	buf.WriteString(" " + lock.column + " = " + lock.column + " + 1,")
+	if where == -1 {
+		buf.WriteString(strings.TrimRight(body[set:], " \t\r\n"))
+		buf.WriteString(" WHERE ")
+	} else {
+		buf.WriteString(body[set:where])
+		buf.WriteString(" (")
+		buf.WriteString(strings.TrimSpace(body[where:]))
+		buf.WriteString(") AND ")
+	}
+	buf.WriteString(lock.column + " = ?")
+	n := 0
+	for _, token := range sqlTokenIndex(body, true) {
+		if token.text == "?" {
+			n++
+		}
+	}
+	if n > len(params) {
+		n = len(params)
+	}
+	args := make([]any, 0, len(params)+1)
+	args = append(append(append(args, params[:n]...), lock.target.Interface()), params[n:]...)
+	if tail != "" {
+		buf.WriteString(" " + tail)
+	}
+	return buf.String(), args, nil
+}
+
+// next 语句执行成功之后把新的版本号写回参数，参数不是以指针传入时无法回写
+func (lock *versionLock) next() {
+	if !lock.target.CanSet() {
+		return
+	}
+	switch lock.target.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		lock.target.SetUint(lock.target.Uint() + 1)
+	default:
+		lock.target.SetInt(lock.target.Int() + 1)
+	}
+}
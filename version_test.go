@@ -0,0 +1,105 @@
+package gobatis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVersionRewrite(t *testing.T) {
+	type Entity struct {
+		Id      int
+		Version int64 `gobatis:"version"`
+	}
+	entity := &Entity{Id: 1, Version: 3}
+	lock, err := versionOf([]reflect.Value{reflect.ValueOf("ctx"), reflect.ValueOf(entity)})
+	if err != nil || lock == nil || lock.column != "version" {
+		t.Fatal(lock, err)
+	}
+	cases := []struct {
+		sql    string
+		params []any
+		want   string
+		args   []any
+	}{
+		{"update user set name = ? where id = ? or name = ?", []any{"a", 1, "b"},
+			"update user set version = version + 1, name = ? where (id = ? or name = ?) AND version = ?", []any{"a", 1, "b", int64(3)}},
+		{"update user set name = ?", []any{"a"},
+			"update user set version = version + 1, name = ? WHERE version = ?", []any{"a", int64(3)}},
+		{"update user set name = ? where id > ? order by id limit ?", []any{"a", 1, 10},
+			"update user set version = version + 1, name = ? where (id > ?) AND version = ? order by id limit ?", []any{"a", 1, int64(3), 10}},
+		// 子查询中的 WHERE 不影响外层条件的位置
+		{"update user set score = (select max(score) from game where game.uid = ?) where id = ?", []any{1, 1},
+			"update user set version = version + 1, score = (select max(score) from game where game.uid = ?) where (id = ?) AND version = ?", []any{1, 1, int64(3)}},
+	}
+	for _, c := range cases {
+		sql, args, err := lock.rewrite(c.sql, c.params)
+		if err != nil || sql != c.want || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("rewrite(%q) = %q %v %v", c.sql, sql, args, err)
+		}
+	}
+	if _, _, err = lock.rewrite("delete from user where id = ?", []any{1}); err == nil {
+		t.Error("rewrite without SET")
+	}
+	lock.next()
+	if entity.Version != 4 {
+		t.Error("next", entity.Version)
+	}
+}
+
+type versionUser struct {
+	Id      int64 `column:"id"`
+	Name    string
+	Version int64 `gobatis:"version"`
+}
+
+type versionMapper struct {
+	Update func(ctx context.Context, user *versionUser) (int64, error)
+}
+
+func TestVersionAfterCommit(t *testing.T) {
+	batis := newFakeBatis(t, &fakeDB{}, `
+<mapper namespace="versionMapper">
+    <update id="Update">update user set name = {name} where id = {id}</update>
+</mapper>`)
+	mapper := &versionMapper{}
+	batis.ScanMappers(mapper)
+	user := &versionUser{Id: 1, Version: 1}
+	if _, err := mapper.Update(context.Background(), user); err != nil || user.Version != 2 {
+		t.Fatal(user.Version, err)
+	}
+
+	// Transaction 中的语句等到事务提交之后回写版本号
+	err := batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+		if _, err := mapper.Update(ctx, user); err != nil {
+			return err
+		}
+		if user.Version != 2 {
+			t.Error("version written back before commit", user.Version)
+		}
+		return nil
+	})
+	if err != nil || user.Version != 3 {
+		t.Fatal(user.Version, err)
+	}
+	abort := errors.New("abort")
+	err = batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+		mapper.Update(ctx, user)
+		return abort
+	})
+	if err != abort || user.Version != 3 {
+		t.Fatal("rolled back transaction must not write back the version", user.Version, err)
+	}
+	// 嵌套事务回滚到保存点时丢弃回写
+	batis.Transaction(context.Background(), nil, func(ctx context.Context) error {
+		batis.Transaction(ctx, nil, func(ctx context.Context) error {
+			mapper.Update(ctx, user)
+			return abort
+		})
+		return nil
+	})
+	if user.Version != 3 {
+		t.Fatal("rolled back savepoint must not write back the version", user.Version)
+	}
+}